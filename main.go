@@ -8,12 +8,36 @@ import (
 )
 
 var (
-	FileNum     = 20
-	SFailureNum = 5
-	DFailureNum = 5
-	UpdateNum   = 5
-	MaxFileSize = 200
-	BasePath    = "./raid6_cluster"
+	FileNum      = 20
+	SFailureNum  = 5
+	DFailureNum  = 5
+	UpdateNum    = 5
+	MaxFileSize  = 200
+	ChunkSize    = 16 // Small chunks so test files span several stripes
+	StreamSize   = 100000
+	SeekNum      = 20
+	CorruptNum   = 10
+	ScrubRate    = int64(0) // Unlimited
+	RebuildRate  = int64(256 * 1024)
+	AddNodeNum   = 2
+	RestripeSize = 32 * 1024 * 1024
+	RestripeRate = int64(32 * 1024 * 1024)
+	BasePath     = "./raid6_cluster"
+	CodeBasePath = "./raid6_code_cluster"
+	JournalPath  = "./raid6_journal_cluster"
+	JournalNum   = 20
+	DecomPath    = "./raid6_decommission_cluster"
+	NamePath     = "./raid6_name_cluster"
+	CodeDiskNum  = 10
+	RSParityNum  = 4
+	WideDataNum  = 250 // With 8 parities one block more than GF(2^8) can address
+	BenchBlock   = 64 * 1024
+	BenchRounds  = 20
+	RangeWorkers = 4
+	RangeSizes   = []int{32769, 49153, 65537, 131073}
+	MemScenarios = 1000
+	WriteAtSize  = 20000
+	WriteAtNum   = 50
 )
 
 func main() {
@@ -22,7 +46,12 @@ func main() {
 		return
 	}
 
-	raid := raid6.InitRAID6(8, BasePath)
+	raid, err := raid6.InitRAID6(8, BasePath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	raid.ChunkSize = ChunkSize
 
 	// Generate random file names and contents
 	err = test.GenerateRandomTestData(FileNum, SFailureNum, DFailureNum, MaxFileSize, raid.DiskNum)
@@ -34,5 +63,57 @@ func main() {
 	// Run recovery tests
 	test.RunRecoveryTests(raid)
 
+	test.RunDegradedReadTests(raid)
+
 	test.RunUpdateTests(raid, UpdateNum, MaxFileSize)
+
+	test.RunBinaryRoundTripTests(raid, FileNum, MaxFileSize)
+
+	test.RunDeleteTests(raid, FileNum)
+
+	test.RunOverwriteTests(raid.DiskNum, MaxFileSize)
+
+	test.RunFileNameTests(NamePath, 8, MaxFileSize)
+
+	test.RunStreamTests(raid, StreamSize, SeekNum)
+
+	test.RunScrubTests(raid, CorruptNum, ScrubRate)
+
+	test.RunSyndromeRepairTests(raid, raid.DiskNum-raid.Code.ParityBlocks(), ChunkSize)
+
+	test.RunWriteAtTests(raid, WriteAtSize, WriteAtNum, MaxFileSize)
+
+	test.RunRebuildTests(raid, RebuildRate)
+
+	test.RunExpansionTests(raid, AddNodeNum)
+
+	test.RunRestripeTests(8, RestripeSize, RestripeRate)
+
+	test.RunDecommissionTests(raid)
+
+	test.RunHotSpareTests(raid, BasePath)
+
+	test.RunPersistenceTests(BasePath)
+
+	test.RunJournalTests(JournalPath, 8, JournalNum, ChunkSize)
+
+	test.RunDecommissionCrashTests(DecomPath, 8, FileNum, MaxFileSize)
+
+	test.RunGFKernelBenchmarks(raid, raid.DiskNum-raid.Code.ParityBlocks(), BenchBlock, BenchRounds)
+
+	test.RunRangeCoverageTests(raid, raid.DiskNum-raid.Code.ParityBlocks(), RangeWorkers, RangeSizes)
+
+	// Clusters beyond two parities, each built from scratch
+	code, err := raid6.NewReedSolomon(RSParityNum)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, opt := range []raid6.Option{raid6.WithErasureCode(code), raid6.WithTripleParity()} {
+		test.RunErasureCodeTests(CodeBasePath, CodeDiskNum, FileNum, MaxFileSize, opt, raid6.WithMemoryStore())
+	}
+
+	test.RunCodeLimitTests(CodeBasePath, WideDataNum, raid6.MaxParityBlocks)
+
+	test.RunMemoryStoreTests(8, FileNum, MaxFileSize, MemScenarios)
 }
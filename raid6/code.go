@@ -0,0 +1,224 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	PQCodeName          = "pq"
+	PQRCodeName         = "pqr"
+	ReedSolomonCodeName = "reed-solomon"
+)
+
+// MaxStripeBlocks Largest number of blocks in a stripe, data and parity. Every block of a stripe
+// needs its own element of GF(2^8) in the coding matrix.
+const MaxStripeBlocks = 256
+
+// ErasureCode Computes the parity blocks of a stripe and rebuilds lost blocks from the rest of it.
+// Parity block i of a stripe has block ID -(i+1), missing blocks are passed as empty slices.
+type ErasureCode interface {
+	Name() string
+	ParityBlocks() int
+	Encode(dataBlocks [][]byte, blockSize int) [][]byte
+	ReconstructData(dataBlocks, parity [][]byte) error // Rebuild missing data blocks in place
+	Reconstruct(dataBlocks, parity [][]byte) error     // Rebuild missing data blocks and parities in place
+}
+
+// DeltaEncoder Implemented by codes that can patch the parities of a stripe for a changed data block
+// from the change alone, without reading the other data blocks
+type DeltaEncoder interface {
+	UpdateParity(parity [][]byte, numDataBlocks, index int, delta []byte) // delta is D + D' of data block index
+}
+
+// Option Configures a cluster created by InitRAID6
+type Option func(*RAID6)
+
+// WithErasureCode Protect the stripes of the cluster with the given code instead of P and Q parity
+func WithErasureCode(code ErasureCode) Option {
+	return func(r *RAID6) {
+		r.Code = code
+	}
+}
+
+// WithTripleParity Protect the stripes of the cluster with P, Q and R parity, tolerating three failed nodes
+func WithTripleParity() Option {
+	return func(r *RAID6) {
+		r.Code = newPQRCode(r.Math)
+	}
+}
+
+// newErasureCode Recreate the code a cluster was formatted with
+func newErasureCode(name string, parityBlocks int, math *RAIDMath) (ErasureCode, error) {
+	switch name {
+	case "", PQCodeName:
+		return newPQCode(math), nil
+	case PQRCodeName:
+		return newPQRCode(math), nil
+	case ReedSolomonCodeName:
+		return newReedSolomon(parityBlocks) // Clusters formatted with two Cauchy parities keep them
+	default:
+		return nil, fmt.Errorf("unknown erasure code %s", name)
+	}
+}
+
+// checkStripeWidth Make sure stripes across numDisks nodes hold at least one data block next to the
+// parities of the code, and fit the field the code works in
+func checkStripeWidth(code ErasureCode, numDisks int) error {
+	if numDisks <= code.ParityBlocks() {
+		return fmt.Errorf("%d nodes leave no room for data next to %d parities, at least %d are needed", numDisks, code.ParityBlocks(), code.ParityBlocks()+1)
+	}
+	if numDisks > MaxStripeBlocks {
+		return fmt.Errorf("stripes of %d blocks exceed the %d blocks supported over GF(2^8)", numDisks, MaxStripeBlocks)
+	}
+	return nil
+}
+
+// pqCode The classic RAID 6 code: P is the XOR of the data blocks, Q weights block j with g^j
+type pqCode struct {
+	math *RAIDMath
+}
+
+func newPQCode(math *RAIDMath) *pqCode {
+	return &pqCode{math: math}
+}
+
+func (c *pqCode) Name() string {
+	return PQCodeName
+}
+
+func (c *pqCode) ParityBlocks() int {
+	return 2
+}
+
+func (c *pqCode) Encode(dataBlocks [][]byte, blockSize int) [][]byte {
+	P, Q := c.math.CalculateParity(dataBlocks, blockSize)
+	return [][]byte{P, Q}
+}
+
+func (c *pqCode) UpdateParity(parity [][]byte, numDataBlocks, index int, delta []byte) {
+	c.math.UpdatePParity(parity[0], delta)
+	c.math.UpdateQParity(parity[1], delta, index)
+}
+
+func (c *pqCode) ReconstructData(dataBlocks, parity [][]byte) error {
+	P, Q := parity[0], parity[1]
+	var missing []int
+	for i, dataBlock := range dataBlocks {
+		if len(dataBlock) == 0 {
+			missing = append(missing, i)
+		}
+	}
+
+	switch {
+	case len(missing) == 0:
+		// All data blocks are available, nothing to rebuild
+	case len(missing) == 1 && len(P) != 0:
+		c.math.RecoverSingleBlockP(dataBlocks, P, missing[0])
+	case len(missing) == 1 && len(Q) != 0:
+		c.math.RecoverSingleBlockQ(dataBlocks, Q, missing[0])
+	case len(missing) == 2 && len(P) != 0 && len(Q) != 0:
+		c.math.RecoverTwoDataBlocks(dataBlocks, P, Q, missing[0], missing[1])
+	default:
+		return errors.New("too many node failures to reconstruct file")
+	}
+
+	return nil
+}
+
+func (c *pqCode) Reconstruct(dataBlocks, parity [][]byte) error {
+	err := c.ReconstructData(dataBlocks, parity)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(parity[0]) == 0 && len(parity[1]) == 0:
+		parity[0], parity[1] = c.math.RecoverPQParities(dataBlocks)
+	case len(parity[0]) == 0:
+		parity[0] = c.math.RecoverPParity(dataBlocks)
+	case len(parity[1]) == 0:
+		parity[1] = c.math.RecoverQParity(dataBlocks)
+	}
+	return nil
+}
+
+// pqrCode Triple parity: P and Q as in pqCode, R weights data block j with g^2j
+type pqrCode struct {
+	math *RAIDMath
+}
+
+func newPQRCode(math *RAIDMath) *pqrCode {
+	return &pqrCode{math: math}
+}
+
+func (c *pqrCode) Name() string {
+	return PQRCodeName
+}
+
+func (c *pqrCode) ParityBlocks() int {
+	return 3
+}
+
+func (c *pqrCode) Encode(dataBlocks [][]byte, blockSize int) [][]byte {
+	P, Q, R := c.math.CalculateTripleParity(dataBlocks, blockSize)
+	return [][]byte{P, Q, R}
+}
+
+func (c *pqrCode) UpdateParity(parity [][]byte, numDataBlocks, index int, delta []byte) {
+	c.math.UpdatePParity(parity[0], delta)
+	c.math.UpdateQParity(parity[1], delta, index)
+	c.math.UpdateRParity(parity[2], delta, index)
+}
+
+func (c *pqrCode) ReconstructData(dataBlocks, parity [][]byte) error {
+	P, Q, R := parity[0], parity[1], parity[2]
+	var missing []int
+	for i, dataBlock := range dataBlocks {
+		if len(dataBlock) == 0 {
+			missing = append(missing, i)
+		}
+	}
+
+	// Use the parities that survived, every combination of three lost blocks has a routine
+	switch {
+	case len(missing) == 0:
+		// All data blocks are available, nothing to rebuild
+	case len(missing) == 1 && len(P) != 0:
+		c.math.RecoverSingleBlockP(dataBlocks, P, missing[0])
+	case len(missing) == 1 && len(Q) != 0:
+		c.math.RecoverSingleBlockQ(dataBlocks, Q, missing[0])
+	case len(missing) == 1 && len(R) != 0:
+		c.math.RecoverSingleBlockR(dataBlocks, R, missing[0])
+	case len(missing) == 2 && len(P) != 0 && len(Q) != 0:
+		c.math.RecoverTwoDataBlocks(dataBlocks, P, Q, missing[0], missing[1])
+	case len(missing) == 2 && len(P) != 0 && len(R) != 0:
+		c.math.RecoverTwoDataBlocksPR(dataBlocks, P, R, missing[0], missing[1])
+	case len(missing) == 2 && len(Q) != 0 && len(R) != 0:
+		c.math.RecoverTwoDataBlocksQR(dataBlocks, Q, R, missing[0], missing[1])
+	case len(missing) == 3 && len(P) != 0 && len(Q) != 0 && len(R) != 0:
+		c.math.RecoverThreeDataBlocks(dataBlocks, P, Q, R, missing[0], missing[1], missing[2])
+	default:
+		return errors.New("too many node failures to reconstruct file")
+	}
+
+	return nil
+}
+
+func (c *pqrCode) Reconstruct(dataBlocks, parity [][]byte) error {
+	err := c.ReconstructData(dataBlocks, parity)
+	if err != nil {
+		return err
+	}
+
+	if len(parity[0]) == 0 {
+		parity[0] = c.math.RecoverPParity(dataBlocks)
+	}
+	if len(parity[1]) == 0 {
+		parity[1] = c.math.RecoverQParity(dataBlocks)
+	}
+	if len(parity[2]) == 0 {
+		parity[2] = c.math.RecoverRParity(dataBlocks)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package raid6
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// DecommissionNode Retire a node without downtime. Every file is restriped onto the remaining nodes and
+// verified before the node leaves the cluster, the nodes after it move down one node ID.
+func (r *RAID6) DecommissionNode(nodeID int) error {
+	r.Lock()
+	err := r.checkDecommission(nodeID)
+	if err != nil {
+		r.Unlock()
+		return err
+	}
+	node := r.Nodes[nodeID]
+	node.retiring = true // Files written from now on avoid the node
+	nodes := r.layoutNodes()
+	r.Unlock()
+
+	err = r.NewRestripeJob(r.RestripeRate, nodes...).Run()
+
+	r.Lock()
+	defer r.Unlock()
+	defer r.promoteDropped()
+	if err == nil {
+		err = r.checkEvacuated(nodeID)
+	}
+	if err != nil {
+		node.retiring = false // Files already moved stay valid where they are
+		return fmt.Errorf("decommission of node %d failed: %w", nodeID, err)
+	}
+	return r.removeNode(nodeID)
+}
+
+// checkDecommission Make sure the node can leave the cluster without losing redundancy
+func (r *RAID6) checkDecommission(nodeID int) error {
+	if nodeID < 0 || nodeID >= len(r.Nodes) {
+		return fmt.Errorf("node %d does not exist", nodeID)
+	}
+	// Keep room for at least one data block next to the parities
+	if minNodes := r.Code.ParityBlocks() + 1; len(r.Nodes)-1 < minNodes {
+		return fmt.Errorf("at least %d nodes must remain", minNodes)
+	}
+	if len(r.layoutNodes()) != len(r.Nodes) {
+		return errors.New("another node is being decommissioned")
+	}
+	if len(r.rebuilds) > 0 || len(r.restripes) > 0 {
+		return errors.New("a rebuild or restripe is running")
+	}
+	if len(r.creating) > 0 {
+		return errors.New("files are being written")
+	}
+	for _, node := range r.Nodes {
+		if node.NodeID != nodeID && !node.status {
+			return fmt.Errorf("node %d is not active", node.NodeID)
+		}
+	}
+	return nil
+}
+
+// checkEvacuated Verify that no file is striped across the node anymore
+func (r *RAID6) checkEvacuated(nodeID int) error {
+	for _, fileName := range r.FileNames {
+		meta, err := r.getFileMeta(fileName)
+		if err != nil {
+			return err
+		}
+		if meta.onNodes(nodeID) {
+			return fmt.Errorf("file %s still has blocks on node %d", fileName, nodeID)
+		}
+	}
+	return nil
+}
+
+// removeNode Drop an evacuated node from the cluster and renumber the nodes after it. The renumbered
+// metadata, tombstones and membership are journaled first, so a crash partway through is finished by
+// replayRenumbering when the cluster is reopened.
+func (r *RAID6) removeNode(nodeID int) error {
+	renumber := func(id int) int {
+		if id > nodeID {
+			return id - 1
+		}
+		return id
+	}
+
+	// Collect what refers to nodes by ID while the old numbering is still in place
+	rn := &Renumbering{}
+	for _, fileName := range r.FileNames {
+		meta, err := r.getFileMeta(fileName)
+		if err != nil {
+			return err
+		}
+		for i, id := range meta.Nodes {
+			meta.Nodes[i] = renumber(id)
+		}
+		rn.Metas = append(rn.Metas, meta)
+	}
+	t := r.readTombstones()
+	pending := make(map[int][]string)
+	for id, fileNames := range t.Pending {
+		if id != nodeID {
+			pending[renumber(id)] = fileNames
+		}
+	}
+	t.Pending = pending
+	t.Seq++
+	rn.Tombstones = t
+
+	node := r.Nodes[nodeID]
+	r.Nodes = append(r.Nodes[:nodeID], r.Nodes[nodeID+1:]...)
+	for i, n := range r.Nodes {
+		n.NodeID = i
+	}
+	r.DiskNum--
+	r.Epoch++
+	rn.Membership = r.membership()
+	for _, meta := range rn.Metas {
+		meta.Epoch = r.Epoch // Tells readers holding the old metadata that its node IDs moved
+	}
+
+	err := r.logRenumbering(rn)
+	if err != nil {
+		return err
+	}
+	err = r.applyRenumbering(rn)
+	if err != nil {
+		return err
+	}
+	err = r.clearRenumbering()
+	if err != nil {
+		return err
+	}
+
+	// The retired disk no longer belongs to the cluster
+	node.NodeID = -1
+	node.status = false
+	node.retiring = false
+	return node.RemoveSuperblock()
+}
+
+const renumberingFileName = "renumbering.json"
+
+// Renumbering Write-ahead record of a decommission. Node IDs shift when a node leaves, so every record
+// naming nodes by ID is stored here with its new numbering and written out from the journal.
+type Renumbering struct {
+	Membership *Superblock `json:"membership"` // Membership after the node left, without the fields of a particular disk
+	Metas      []*FileMeta `json:"metas"`      // Metadata of every file with the new node IDs
+	Tombstones *Tombstones `json:"tombstones"` // Pending deletions keyed by the new node IDs
+}
+
+func readRenumbering(store BlockStore) (*Renumbering, error) {
+	data, err := store.Get(renumberingFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	rn := &Renumbering{}
+	err = json.Unmarshal(data, rn)
+	if err != nil || rn.Membership == nil || rn.Tombstones == nil {
+		return nil, errors.New("invalid renumbering journal")
+	}
+	return rn, nil
+}
+
+// logRenumbering Store the journal on every node that stays in the cluster
+func (r *RAID6) logRenumbering(rn *Renumbering) error {
+	data, err := json.Marshal(rn)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range r.Nodes {
+		if !node.writable() {
+			continue
+		}
+		err = node.Store.Put(renumberingFileName, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearRenumbering Remove the journal from every node once the renumbering is complete
+func (r *RAID6) clearRenumbering() error {
+	for _, node := range r.Nodes {
+		if !node.writable() {
+			continue
+		}
+		err := node.Store.Delete(renumberingFileName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRenumbering Write the journaled metadata, tombstones and membership. Records changed since the
+// journal was written are left alone, so the journal can be applied any number of times.
+func (r *RAID6) applyRenumbering(rn *Renumbering) error {
+	for _, meta := range rn.Metas {
+		current, err := r.getFileMeta(meta.FileName)
+		if err != nil || current.FileID != meta.FileID || current.Layout != meta.Layout || current.Version != meta.Version {
+			continue // Deleted or rewritten since
+		}
+		err = r.writeFileMeta(meta)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.readTombstones().Seq <= rn.Tombstones.Seq {
+		for _, node := range r.Nodes {
+			if !node.status {
+				continue
+			}
+			if t, err := node.ReadTombstones(); err == nil && t.Seq == rn.Tombstones.Seq {
+				continue // Written before the interruption
+			}
+			err := node.WriteTombstones(rn.Tombstones)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return r.writeMembership()
+}
+
+// replayRenumbering Finish a decommission an earlier process was interrupted in. A journal older than
+// the current membership is obsolete and only removed.
+func (r *RAID6) replayRenumbering(rn *Renumbering) error {
+	if rn.Membership.ClusterID == r.ClusterID && rn.Membership.Epoch >= r.Epoch {
+		err := r.applyRenumbering(rn)
+		if err != nil {
+			return fmt.Errorf("replay of node renumbering failed: %w", err)
+		}
+	}
+	return r.clearRenumbering()
+}
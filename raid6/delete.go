@@ -0,0 +1,157 @@
+package raid6
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+)
+
+const tombstoneFileName = "tombstones.json"
+
+// Tombstones Files deleted while some of their nodes were offline, kept on every active node
+type Tombstones struct {
+	Seq     int              `json:"seq"`     // Incremented on every change, the highest copy wins
+	Pending map[int][]string `json:"pending"` // Node ID to the files still to remove from that node
+}
+
+// ReadTombstones reads the pending deletions stored on the node
+func (n *Node) ReadTombstones() (*Tombstones, error) {
+	data, err := n.Store.Get(tombstoneFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Tombstones{Pending: make(map[int][]string)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tombstones{}
+	err = json.Unmarshal(data, t)
+	if err != nil {
+		return nil, err
+	}
+	if t.Pending == nil {
+		t.Pending = make(map[int][]string)
+	}
+	return t, nil
+}
+
+// WriteTombstones writes the pending deletions to the node
+func (n *Node) WriteTombstones(t *Tombstones) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	return n.Store.Put(tombstoneFileName, data)
+}
+
+// readTombstones Return the most recent copy of the pending deletions among the active nodes
+func (r *RAID6) readTombstones() *Tombstones {
+	latest := &Tombstones{Pending: make(map[int][]string)}
+	for _, node := range r.Nodes {
+		if !node.status {
+			continue
+		}
+		t, err := node.ReadTombstones()
+		if err != nil {
+			continue // Damaged copy, rely on the other nodes
+		}
+		if t.Seq > latest.Seq {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// writeTombstones Store a new version of the pending deletions on every active node
+func (r *RAID6) writeTombstones(t *Tombstones) error {
+	t.Seq++
+	for _, node := range r.Nodes {
+		if !node.status {
+			continue
+		}
+		err := node.WriteTombstones(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyTombstones Remove the files deleted while the node was offline, called when the node comes back
+func (r *RAID6) applyTombstones(node *Node) error {
+	t := r.readTombstones()
+	fileNames, ok := t.Pending[node.NodeID]
+	if !ok {
+		return nil
+	}
+
+	for _, fileName := range fileNames {
+		err := node.DeleteFileFromDisk(fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(t.Pending, node.NodeID)
+	return r.writeTombstones(t)
+}
+
+// DeleteFile Remove all data and parity blocks of a file and free its name.
+// Offline nodes get a tombstone and drop their blocks when they come back.
+func (r *RAID6) DeleteFile(fileName string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.fileExists(fileName) {
+		return errors.New("file does not exist")
+	}
+	if r.creating[fileName] {
+		return errors.New("file is being replaced")
+	}
+
+	return r.deleteFile(fileName)
+}
+
+// deleteFile Remove a file from every node and from the catalog
+func (r *RAID6) deleteFile(fileName string) error {
+	r.touchRestripe(fileName, true)
+	t := r.readTombstones()
+	deferred := false
+	for _, node := range r.Nodes {
+		if !node.writable() {
+			t.Pending[node.NodeID] = appendUnique(t.Pending[node.NodeID], fileName)
+			deferred = true
+			continue
+		}
+		err := node.DeleteFileFromDisk(fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if deferred {
+		err := r.writeTombstones(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, name := range r.FileNames {
+		if name == fileName {
+			r.FileNames = append(r.FileNames[:i], r.FileNames[i+1:]...)
+			r.FileNum--
+			break
+		}
+	}
+	return nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, item := range list {
+		if item == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
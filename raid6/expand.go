@@ -0,0 +1,387 @@
+package raid6
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RestripeProgress Snapshot of a restripe job
+type RestripeProgress struct {
+	Nodes       []int
+	FilesDone   int
+	FilesTotal  int
+	BytesMoved  int64
+	CurrentFile string
+	Done        bool
+	Err         error
+}
+
+// RestripeJob Moves every file to a layout across the given nodes, one file and one stripe at a time.
+// Each file is written and verified in its new layout next to the old one, so it stays readable and
+// writable throughout.
+type RestripeJob struct {
+	raid  *RAID6
+	Nodes []int // Nodes the files are striped across once the job is done
+	Rate  int64 // I/O limit in bytes per second, 0 for unlimited
+
+	mu          sync.Mutex
+	filesDone   int
+	filesTotal  int
+	bytesMoved  int64
+	currentFile string
+	finished    bool
+	err         error
+	done        chan struct{}
+}
+
+// NewRestripeJob Create a job moving all files onto the given nodes, at most rate bytes per second
+func (r *RAID6) NewRestripeJob(rate int64, nodes ...int) *RestripeJob {
+	return &RestripeJob{
+		raid:  r,
+		Nodes: nodes,
+		Rate:  rate,
+		done:  make(chan struct{}),
+	}
+}
+
+// Restripes Return the restripe jobs started by the cluster itself that are still running
+func (r *RAID6) Restripes() []*RestripeJob {
+	r.Lock()
+	defer r.Unlock()
+
+	return append([]*RestripeJob(nil), r.restripes...)
+}
+
+// startRestripe Move all files onto the given nodes in the background at the cluster's restripe rate
+func (r *RAID6) startRestripe(nodes []int) *RestripeJob {
+	job := r.NewRestripeJob(r.RestripeRate, nodes...)
+	r.restripes = append(r.restripes, job)
+
+	go func() {
+		job.Run()
+
+		r.Lock()
+		defer r.Unlock()
+		for i, j := range r.restripes {
+			if j == job {
+				r.restripes = append(r.restripes[:i], r.restripes[i+1:]...)
+				break
+			}
+		}
+	}()
+	return job
+}
+
+// AddNodes Grow the cluster by k nodes. Files written afterwards are striped across all nodes right away,
+// existing files are moved onto the wider layout by the returned background job.
+func (r *RAID6) AddNodes(k int) (*RestripeJob, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if k <= 0 {
+		return nil, errors.New("number of nodes to add must be positive")
+	}
+	if len(r.layoutNodes()) != len(r.Nodes) {
+		return nil, errors.New("a node is being decommissioned")
+	}
+	err := checkStripeWidth(r.Code, len(r.Nodes)+k)
+	if err != nil {
+		return nil, err
+	}
+
+	basePath := filepath.Dir(r.Nodes[0].DiskPath)
+	for i := 0; i < k; i++ {
+		nodeID := len(r.Nodes)
+		node := r.initNode(nodeID, newDiskPath(basePath, nodeID))
+		err = r.formatNode(node)
+		if err != nil {
+			return nil, err
+		}
+		r.Nodes = append(r.Nodes, node)
+		r.DiskNum++
+	}
+
+	err = r.updateMembership()
+	if err != nil {
+		return nil, err
+	}
+	return r.startRestripe(r.layoutNodes()), nil
+}
+
+// newDiskPath Pick a directory for a new node that is not in use by an earlier disk
+func newDiskPath(basePath string, nodeID int) string {
+	diskPath := fmt.Sprintf("%s/disk_%d", basePath, nodeID)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(diskPath); os.IsNotExist(err) {
+			return diskPath
+		}
+		diskPath = fmt.Sprintf("%s/disk_%d_%d", basePath, nodeID, i)
+	}
+}
+
+// Wait Block until the job has finished and return its error
+func (j *RestripeJob) Wait() error {
+	<-j.done
+	return j.Progress().Err
+}
+
+// Progress Return how far the job has come
+func (j *RestripeJob) Progress() RestripeProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return RestripeProgress{
+		Nodes:       j.Nodes,
+		FilesDone:   j.filesDone,
+		FilesTotal:  j.filesTotal,
+		BytesMoved:  j.bytesMoved,
+		CurrentFile: j.currentFile,
+		Done:        j.finished,
+		Err:         j.err,
+	}
+}
+
+// Run Restripe every file of the catalog
+func (j *RestripeJob) Run() error {
+	err := j.run()
+
+	j.mu.Lock()
+	j.err = err
+	j.finished = true
+	j.currentFile = ""
+	j.mu.Unlock()
+	close(j.done)
+	return err
+}
+
+func (j *RestripeJob) run() error {
+	r := j.raid
+	limit := newThrottle(j.Rate)
+
+	r.Lock()
+	fileNames := append([]string(nil), r.FileNames...)
+	r.Unlock()
+
+	j.mu.Lock()
+	j.filesTotal = len(fileNames)
+	j.mu.Unlock()
+
+	for _, fileName := range fileNames {
+		j.mu.Lock()
+		j.currentFile = fileName
+		j.mu.Unlock()
+
+		// A file written in place while it is moved is moved again from its new content
+		err := errRestripeOutdated
+		for errors.Is(err, errRestripeOutdated) {
+			err = j.restripeFile(fileName, limit)
+		}
+		if err != nil {
+			return fmt.Errorf("restripe of file %s failed: %w", fileName, err)
+		}
+
+		j.mu.Lock()
+		j.filesDone++
+		j.mu.Unlock()
+	}
+	return nil
+}
+
+// errRestripeOutdated Returned when a file changed while it was moved, the partial new layout is dropped
+var errRestripeOutdated = errors.New("file changed while it was restriped")
+
+// restripeTarget A file being moved by a restripe job
+type restripeTarget struct {
+	old     *FileMeta // Layout the file is moved from
+	meta    *FileMeta // Layout the file is moved to, its version is not handed out to writers of the file
+	changed bool      // Written, replaced or deleted since the move started, the new layout is outdated
+	deleted bool      // Deleted since the move started, which removed the blocks of every layout
+}
+
+// touchRestripe Record that a file changed, so a restripe moving it starts over
+func (r *RAID6) touchRestripe(fileName string, deleted bool) {
+	if t := r.restriping[fileName]; t != nil {
+		t.changed = true
+		t.deleted = t.deleted || deleted
+	}
+}
+
+// restripeFile Move a file to a new layout version across the job's nodes, one stripe at a time, so
+// foreground requests wait for a single stripe and only about one stripe of the file is held in memory.
+// Every stripe of the new layout is written and verified next to the old one, switching the metadata
+// makes it current and only then the blocks of the old layout are removed.
+func (j *RestripeJob) restripeFile(fileName string, limit *throttle) error {
+	r := j.raid
+	r.Lock()
+	target, err := r.planRestripe(fileName, j.Nodes)
+	r.Unlock()
+	if err != nil || target == nil {
+		return err
+	}
+	oldMeta, meta := target.old, target.meta
+	defer func() {
+		r.Lock()
+		delete(r.restriping, fileName)
+		r.Unlock()
+	}()
+
+	// Old stripes are read into buf until it covers the next new stripe
+	stripeSize := meta.BlockSize * meta.DataDisks
+	buf := make([]byte, 0, oldMeta.BlockSize*oldMeta.DataDisks+stripeSize)
+	read, oldStripe := 0, 0
+	for stripe := 0; stripe < meta.Stripes; stripe++ {
+		n := min(stripeSize, meta.Size-stripe*stripeSize)
+
+		r.Lock()
+		err = r.checkRestripe(target)
+		for err == nil && len(buf) < n {
+			var dataBlocks [][]byte
+			dataBlocks, err = r.readStripeData(oldMeta, oldStripe)
+			for _, dataBlock := range dataBlocks {
+				take := min(len(dataBlock), oldMeta.Size-read)
+				buf = append(buf, dataBlock[:take]...)
+				read += take
+			}
+			oldStripe++
+		}
+		if err == nil {
+			dataBlocks := splitChunk(meta, buf[:n])
+			err = r.writeStripe(meta, stripe, dataBlocks)
+			if err == nil {
+				err = r.verifyStripe(meta, stripe, dataBlocks)
+			}
+		}
+		if err != nil {
+			r.deleteLayout(fileName, meta.Layout) // Best effort, the old layout is still current
+		}
+		r.promoteDropped()
+		r.Unlock()
+		if err != nil {
+			return err
+		}
+
+		buf = append(buf[:0], buf[n:]...)
+		j.mu.Lock()
+		j.bytesMoved += int64(n)
+		j.mu.Unlock()
+		limit.wait(2 * n) // Read from the old layout, written to the new one
+	}
+
+	r.Lock()
+	err = r.checkRestripe(target)
+	if err != nil {
+		r.deleteLayout(fileName, meta.Layout)
+	} else {
+		err = r.writeFileMeta(meta)
+	}
+	r.promoteDropped()
+	r.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Nothing reads the old layout anymore, its blocks are removed a stripe at a time as well
+	for stripe := 0; stripe < oldMeta.Stripes; stripe++ {
+		r.Lock()
+		if !target.deleted {
+			err = r.deleteStripe(oldMeta, stripe)
+		}
+		r.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planRestripe Plan the next layout version of a file across the given nodes and register the file as
+// being moved. Returns nil if the file is gone, being replaced or already in place.
+func (r *RAID6) planRestripe(fileName string, nodes []int) (*restripeTarget, error) {
+	if !r.fileExists(fileName) {
+		return nil, nil // Deleted since the job started
+	}
+	if r.creating[fileName] {
+		return nil, nil // Being replaced by Create, which writes the next layout version itself
+	}
+	oldMeta, err := r.getFileMeta(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if sameNodeIDs(oldMeta.Nodes, nodes) {
+		return nil, nil // Already in place
+	}
+
+	meta := r.planFileMeta(fileName, oldMeta.Size, nodes)
+	meta.CreatedAt = oldMeta.CreatedAt
+	meta.FileID = oldMeta.FileID
+	meta.Layout = oldMeta.Layout + 1
+	meta.Version = oldMeta.Version
+
+	// Drop whatever an interrupted attempt left of the new layout
+	err = r.deleteLayout(fileName, meta.Layout)
+	if err != nil {
+		return nil, err
+	}
+	target := &restripeTarget{old: oldMeta, meta: meta}
+	r.restriping[fileName] = target
+	return target, nil
+}
+
+// checkRestripe Make sure the file being moved has not changed since its move started. A file Create
+// is replacing would have its old layout removed by Create, the new layout must not become current.
+func (r *RAID6) checkRestripe(target *restripeTarget) error {
+	if target.changed || r.creating[target.meta.FileName] {
+		return errRestripeOutdated
+	}
+	return nil
+}
+
+// verifyStripe Read back a freshly written stripe and compare it with the data it was written from
+func (r *RAID6) verifyStripe(meta *FileMeta, stripe int, expected [][]byte) error {
+	dataBlocks, parity := r.GetStripeBlocks(meta, stripe)
+	for i, nodeID := range r.stripeNodes(meta, stripe) {
+		if r.node(nodeID).status && len(stripeBlock(dataBlocks, parity, blockIDAt(i, meta.DataDisks))) == 0 {
+			return fmt.Errorf("block of stripe %d is missing on node %d", stripe, nodeID)
+		}
+	}
+
+	err := r.Code.Reconstruct(dataBlocks, parity)
+	if err != nil {
+		return err
+	}
+	for i := range expected {
+		if !bytes.Equal(dataBlocks[i], expected[i]) {
+			return fmt.Errorf("data block %d of stripe %d does not match", i, stripe)
+		}
+	}
+	for i, p := range r.Code.Encode(expected, meta.BlockSize) {
+		if !bytes.Equal(parity[i], p) {
+			return fmt.Errorf("parity %d of stripe %d does not match", i, stripe)
+		}
+	}
+	return nil
+}
+
+// metaStale Check if the file was restriped, replaced or deleted, or its nodes renumbered, since meta was read
+func (r *RAID6) metaStale(meta *FileMeta) bool {
+	current, err := r.getFileMeta(meta.FileName)
+	return err != nil || current.FileID != meta.FileID || current.Layout != meta.Layout || current.Epoch != meta.Epoch
+}
+
+// deleteLayout Remove the blocks of one layout version of a file from the active nodes
+func (r *RAID6) deleteLayout(fileName string, layout int) error {
+	for _, node := range r.Nodes {
+		if !node.writable() {
+			continue
+		}
+		err := node.DeleteLayoutFromDisk(fileName, layout)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
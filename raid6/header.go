@@ -0,0 +1,77 @@
+package raid6
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	blockMagic      = "R6BK"
+	blockVersion    = 1
+	blockHeaderSize = 32
+)
+
+// ErrChecksumMismatch Returned when a block read from disk does not match its checksum or header.
+// The RAID layer treats such a block as missing and reconstructs it from the parities.
+var ErrChecksumMismatch = errors.New("block checksum mismatch")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// BlockHeader Header stored in front of every block on disk
+type BlockHeader struct {
+	Checksum uint32 // CRC32C over the rest of the header and the block data
+	BlockID  int
+	Stripe   int
+	Length   int
+	FileID   uint64
+}
+
+// encodeBlock Prepend the header with the checksum of the data
+func encodeBlock(b *Block) []byte {
+	data := *b.Data
+	buf := make([]byte, blockHeaderSize+len(data))
+	copy(buf[0:4], blockMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], blockVersion)
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(int32(b.BlockID)))
+	binary.LittleEndian.PutUint32(buf[16:20], uint32(b.Stripe))
+	binary.LittleEndian.PutUint32(buf[20:24], uint32(len(data)))
+	binary.LittleEndian.PutUint64(buf[24:32], b.FileID)
+	copy(buf[blockHeaderSize:], data)
+
+	binary.LittleEndian.PutUint32(buf[8:12], blockChecksum(buf))
+	return buf
+}
+
+// decodeBlock Verify the header and checksum of a block read from disk and return its data
+func decodeBlock(buf []byte) (*BlockHeader, []byte, error) {
+	if len(buf) < blockHeaderSize || string(buf[0:4]) != blockMagic {
+		return nil, nil, fmt.Errorf("%w: missing block header", ErrChecksumMismatch)
+	}
+	if version := binary.LittleEndian.Uint16(buf[4:6]); version != blockVersion {
+		return nil, nil, fmt.Errorf("unsupported block version %d", version)
+	}
+
+	header := &BlockHeader{
+		Checksum: binary.LittleEndian.Uint32(buf[8:12]),
+		BlockID:  int(int32(binary.LittleEndian.Uint32(buf[12:16]))),
+		Stripe:   int(binary.LittleEndian.Uint32(buf[16:20])),
+		Length:   int(binary.LittleEndian.Uint32(buf[20:24])),
+		FileID:   binary.LittleEndian.Uint64(buf[24:32]),
+	}
+	if header.Length != len(buf)-blockHeaderSize {
+		return nil, nil, fmt.Errorf("%w: block is truncated", ErrChecksumMismatch)
+	}
+	if checksum := blockChecksum(buf); checksum != header.Checksum {
+		return nil, nil, fmt.Errorf("%w: expected %08x, computed %08x", ErrChecksumMismatch, header.Checksum, checksum)
+	}
+
+	return header, buf[blockHeaderSize:], nil
+}
+
+// blockChecksum CRC32C of an encoded block, skipping the checksum field itself
+func blockChecksum(buf []byte) uint32 {
+	checksum := crc32.Update(0, crc32c, buf[0:8])
+	return crc32.Update(checksum, crc32c, buf[12:])
+}
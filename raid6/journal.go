@@ -0,0 +1,201 @@
+package raid6
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+const (
+	intentFileName = "intent.json"
+	maxIntentSize  = 4 * 1024 * 1024 // Bytes of stripe data journaled at once
+)
+
+// Intent Write-ahead record of an update in progress, or of one batch of a large in-place write. It
+// holds the full new content of every stripe it touches, so after a crash it can be finished from the
+// journal alone and no stripe is left with parity computed from a mix of old and new data.
+type Intent struct {
+	Seq        int64          `json:"seq"`         // Time the intent was logged, the latest copy wins
+	Meta       *FileMeta      `json:"meta"`        // Metadata of the file once the update is done
+	Final      bool           `json:"final"`       // The intent switches the metadata once its stripes are written
+	OldStripes int            `json:"old_stripes"` // Stripes before the update, the ones past the new end are dropped by a final intent
+	Stripes    []IntentStripe `json:"stripes"`
+}
+
+// IntentStripe New content of one stripe: either all data blocks, whose parities are computed when
+// the stripe is written, or only the blocks that change, parities included, keyed by block ID
+type IntentStripe struct {
+	Stripe     int            `json:"stripe"`
+	DataBlocks [][]byte       `json:"data_blocks,omitempty"`
+	Blocks     map[int][]byte `json:"blocks,omitempty"`
+}
+
+// ReadIntent reads the pending intent stored on the node
+func (n *Node) ReadIntent() (*Intent, error) {
+	data, err := n.Store.Get(intentFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	intent := &Intent{}
+	err = json.Unmarshal(data, intent)
+	if err != nil || intent.Meta == nil {
+		return nil, fmt.Errorf("invalid intent on node %d", n.NodeID)
+	}
+	return intent, nil
+}
+
+// WriteIntent writes the intent to the node
+func (n *Node) WriteIntent(intent *Intent) error {
+	data, err := json.Marshal(intent)
+	if err != nil {
+		return err
+	}
+
+	return n.Store.Put(intentFileName, data)
+}
+
+// RemoveIntent removes the intent from the node
+func (n *Node) RemoveIntent() error {
+	return n.Store.Delete(intentFileName)
+}
+
+// logIntent Store the intent on enough active nodes to survive as many failures as the stripes do
+func (r *RAID6) logIntent(intent *Intent) error {
+	intent.Seq = time.Now().UnixNano()
+
+	copies := 0
+	for _, node := range r.Nodes {
+		if !node.status {
+			continue
+		}
+		err := node.WriteIntent(intent)
+		if err != nil {
+			return err
+		}
+		copies++
+		if copies > r.Code.ParityBlocks() {
+			return nil
+		}
+	}
+	return errors.New("not enough active nodes to journal the update")
+}
+
+// clearIntent Remove the intent from every node once the update is complete
+func (r *RAID6) clearIntent() error {
+	for _, node := range r.Nodes {
+		if !node.writable() {
+			continue
+		}
+		err := node.RemoveIntent()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journal Log an intent, write it and remove it from the journal again. If the write fails the intent
+// stays pending, to be finished by finishIntent before the next journaled write.
+func (r *RAID6) journal(intent *Intent) error {
+	err := r.logIntent(intent)
+	if err != nil {
+		return err
+	}
+	r.intentPending = true
+	err = r.applyIntent(intent)
+	if err != nil {
+		return err
+	}
+	err = r.clearIntent()
+	if err != nil {
+		return err
+	}
+	r.intentPending = false
+	return nil
+}
+
+// finishIntent Finish the intent of a journaled write that failed partway. Called before a write reads
+// the stripes it may have left torn, or logs an intent that would replace it in the journal.
+func (r *RAID6) finishIntent() error {
+	if !r.intentPending {
+		return nil
+	}
+	err := r.replayIntent()
+	if err != nil {
+		return err
+	}
+	r.intentPending = false
+	return nil
+}
+
+// applyIntent Write every journaled stripe. A final intent also drops the stripes past the new end
+// and switches the metadata.
+func (r *RAID6) applyIntent(intent *Intent) error {
+	meta := intent.Meta
+	r.touchRestripe(meta.FileName, false)
+	for _, s := range intent.Stripes {
+		var err error
+		if s.DataBlocks != nil {
+			err = r.writeStripe(meta, s.Stripe, s.DataBlocks)
+		} else {
+			err = r.writeBlocks(meta, s.Stripe, s.Blocks)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !intent.Final {
+		return nil
+	}
+
+	// The node set is unchanged by an update, so meta locates the old stripes as well
+	for stripe := meta.Stripes; stripe < intent.OldStripes; stripe++ {
+		err := r.deleteStripe(meta, stripe)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.writeFileMeta(meta)
+}
+
+// replayIntent Finish the update an earlier process was interrupted in. An intent whose file was
+// deleted, restriped or updated again since is obsolete and only removed.
+func (r *RAID6) replayIntent() error {
+	var latest *Intent
+	for _, node := range r.Nodes {
+		if !node.status {
+			continue
+		}
+		intent, err := node.ReadIntent()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			continue // Damaged copy, rely on the other nodes
+		}
+		if latest == nil || intent.Seq > latest.Seq {
+			latest = intent
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+
+	// The metadata is switched last, so it is at the old version until the update is complete.
+	// Intents that leave the metadata alone carry the current version.
+	meta := latest.Meta
+	current, err := r.getFileMeta(meta.FileName)
+	if err == nil && current.FileID == meta.FileID && current.Layout == meta.Layout &&
+		(current.Version == meta.Version-1 || current.Version == meta.Version) {
+		err = r.applyIntent(latest)
+		if err != nil {
+			return fmt.Errorf("replay of update to file %s failed: %w", meta.FileName, err)
+		}
+	}
+	return r.clearIntent()
+}
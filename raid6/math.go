@@ -1,13 +1,12 @@
 package raid6
 
-import (
-	"fmt"
-)
+import "encoding/binary"
 
 type RAIDMath struct {
 	generator int
 	gfExp     [512]int
 	gfLog     [256]int
+	gfMul     [256][256]byte // Full multiplication table, row a holds a*b for every b
 	fieldSize int
 }
 
@@ -47,6 +46,13 @@ func (rm *RAIDMath) initGaloisField() {
 	for i := 255; i < 512; i++ {
 		rm.gfExp[i] = rm.gfExp[i-255]
 	}
+
+	// Precompute every product so the slice kernels need a single lookup per byte
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			rm.gfMul[a][b] = byte(rm.GfMul(a, b))
+		}
+	}
 }
 
 // GfAdd Galois Field addition (XOR for GF(2^8))
@@ -84,22 +90,65 @@ func (rm *RAIDMath) GfInverse(a int) int {
 	return rm.gfExp[255-rm.gfLog[a]]
 }
 
+// XorSlice Add in to out (XOR in GF(2^8)), 8 bytes at a time
+func (rm *RAIDMath) XorSlice(in, out []byte) {
+	n := len(in) &^ 7
+	for i := 0; i < n; i += 8 {
+		binary.LittleEndian.PutUint64(out[i:], binary.LittleEndian.Uint64(out[i:])^binary.LittleEndian.Uint64(in[i:]))
+	}
+	for i := n; i < len(in); i++ {
+		out[i] ^= in[i]
+	}
+}
+
+// MulSlice Multiply every byte of in by coef and store the products in out
+func (rm *RAIDMath) MulSlice(coef byte, in, out []byte) {
+	switch coef {
+	case 0:
+		clear(out[:len(in)])
+	case 1:
+		copy(out, in)
+	default:
+		table := &rm.gfMul[coef]
+		out = out[:len(in)]
+		for i, b := range in {
+			out[i] = table[b]
+		}
+	}
+}
+
+// MulSliceXor Multiply every byte of in by coef and add the products to out
+func (rm *RAIDMath) MulSliceXor(coef byte, in, out []byte) {
+	switch coef {
+	case 0:
+	case 1:
+		rm.XorSlice(in, out)
+	default:
+		table := &rm.gfMul[coef]
+		out = out[:len(in)]
+		for i, b := range in {
+			out[i] ^= table[b]
+		}
+	}
+}
+
+// coef Coefficient of data block j in the parity with the given power of the generator: 0 for P, 1 for Q, 2 for R
+func (rm *RAIDMath) coef(power, j int) byte {
+	return byte(rm.GfExp(power * j))
+}
+
 // CalculateParity Calculate P and Q parities for the data blocks with pParity and qParity as *([]byte)
 func (rm *RAIDMath) CalculateParity(dataBlocks [][]byte, blockSize int) ([]byte, []byte) {
 	pParity := make([]byte, blockSize)
 	qParity := make([]byte, blockSize)
 
-	for i := 0; i < blockSize; i++ {
-		p := 0
-		q := 0
-		for j := 0; j < len(dataBlocks); j++ {
-			// Dereference the pointer to access the actual data block slice
-			p = rm.GfAdd(p, int(dataBlocks[j][i]))
-			q = rm.GfAdd(q, rm.GfMul(rm.GfExp(j), int(dataBlocks[j][i]))) // Q uses GF multiplication with generator
+	// Every worker computes both parities over its own byte range of the blocks
+	workers.ranges(blockSize, func(lo, hi int) {
+		for j, dataBlock := range dataBlocks {
+			rm.XorSlice(dataBlock[lo:hi], pParity[lo:hi])
+			rm.MulSliceXor(rm.coef(1, j), dataBlock[lo:hi], qParity[lo:hi]) // Q uses GF multiplication with generator
 		}
-		pParity[i] = byte(p)
-		qParity[i] = byte(q)
-	}
+	})
 
 	return pParity, qParity
 }
@@ -118,50 +167,86 @@ func (rm *RAIDMath) identifyCorruptDataDisk(pStar, qStar int) int {
 	return -1 // If no match is found, return -1 indicating corruption could not be identified
 }
 
-// RepairCorruptedDataBlocks Identify corruption and perform the correct recovery operation
-func (rm *RAIDMath) RepairCorruptedDataBlocks(dataBlocks [][]byte, pParity, qParity []byte) ([][]byte, []byte, []byte) {
-	// Step 1: Recompute P* and Q* syndromes
-	pStar, qStar := rm.recomputeSyndromes(dataBlocks, pParity, qParity)
+// RepairReport Outcome of a per-byte syndrome repair of one stripe
+type RepairReport struct {
+	Checked       int         // Byte positions examined
+	Corrupted     int         // Positions with non-zero syndromes
+	Repaired      int         // Positions that were corrected in place
+	Uncorrectable []int       // Positions whose syndromes do not point to a single block, left untouched
+	Blocks        map[int]int // Block index (-1 for P, -2 for Q) to the number of bytes repaired in it
+}
 
-	// Step 2: Identify the type of the corrupt disk
-	if pStar != 0 && qStar == 0 {
-		// Case 1: P parity is corrupt
-		fmt.Println("P parity is corrupt, recovering...")
-		pParity = rm.RecoverPParity(dataBlocks)
-	} else if pStar == 0 && qStar != 0 {
-		// Case 2: Q parity is corrupt
-		fmt.Println("Q parity is corrupt, recovering...")
-		qParity = rm.RecoverQParity(dataBlocks)
-	} else if pStar != 0 {
-		// Case 3: Data disk is corrupt
-		fmt.Println("Data disk is corrupt, recovering...")
-		corruptDisk := rm.identifyCorruptDataDisk(pStar, qStar)
-		dataBlocks[corruptDisk] = rm.RecoverSingleBlockP(dataBlocks, pParity, corruptDisk)
-	} else {
-		fmt.Println("No corruption detected.")
-	}
+// Clean Check if the stripe was consistent before the repair
+func (rr *RepairReport) Clean() bool {
+	return rr.Corrupted == 0
+}
 
-	return dataBlocks, pParity, qParity
+// Conflicting Check if the repaired bytes are spread over more than one block, which a
+// single failing disk cannot explain
+func (rr *RepairReport) Conflicting() bool {
+	return len(rr.Blocks) > 1
 }
 
-// recomputeSyndromes Recompute P* and Q* syndromes
-func (rm *RAIDMath) recomputeSyndromes(dataBlocks [][]byte, pParity, qParity []byte) (int, int) {
-	pStar := 0
-	qStar := 0
+// RepairCorruptedDataBlocks Identify corruption at every byte position and perform the correct recovery operation.
+// Each position is decoded on its own, so errors in different blocks at different offsets are fixed independently.
+func (rm *RAIDMath) RepairCorruptedDataBlocks(dataBlocks [][]byte, pParity, qParity []byte) ([][]byte, []byte, []byte, *RepairReport) {
+	report := &RepairReport{
+		Checked: len(pParity),
+		Blocks:  make(map[int]int),
+	}
 
-	// Recompute P* and Q* by summing the data blocks
-	for i := 0; i < len(pParity); i++ {
-		pStar = rm.GfAdd(pStar, int(pParity[i]))
-		qStar = rm.GfAdd(qStar, int(qParity[i]))
+	// Step 1: Recompute P* and Q* syndromes for every byte position
+	pStar, qStar := rm.recomputeSyndromes(dataBlocks, pParity, qParity)
 
-		for j := 0; j < len(dataBlocks); j++ {
-			if dataBlocks[j] != nil {
-				pStar = rm.GfAdd(pStar, int(dataBlocks[j][i]))
-				qStar = rm.GfAdd(qStar, rm.GfMul(rm.GfExp(j), int(dataBlocks[j][i])))
+	for i := range pStar {
+		ps, qs := int(pStar[i]), int(qStar[i])
+		if ps == 0 && qs == 0 {
+			continue
+		}
+		report.Corrupted++
+
+		// Step 2: Identify the type of the corrupt block at this position
+		corruptBlock := 0
+		if ps != 0 && qs == 0 {
+			// Case 1: P parity is corrupt
+			corruptBlock = -1
+			pParity[i] = byte(rm.GfAdd(int(pParity[i]), ps))
+		} else if ps == 0 && qs != 0 {
+			// Case 2: Q parity is corrupt
+			corruptBlock = -2
+			qParity[i] = byte(rm.GfAdd(int(qParity[i]), qs))
+		} else {
+			// Case 3: Data block is corrupt, Q*/P* = g^z names it
+			corruptBlock = rm.identifyCorruptDataDisk(ps, qs)
+			if corruptBlock < 0 || corruptBlock >= len(dataBlocks) || dataBlocks[corruptBlock] == nil {
+				report.Uncorrectable = append(report.Uncorrectable, i)
+				continue
 			}
+			dataBlocks[corruptBlock][i] = byte(rm.GfAdd(int(dataBlocks[corruptBlock][i]), ps))
 		}
+
+		report.Repaired++
+		report.Blocks[corruptBlock]++
 	}
 
+	return dataBlocks, pParity, qParity, report
+}
+
+// recomputeSyndromes Recompute P* and Q* syndromes for every byte position
+func (rm *RAIDMath) recomputeSyndromes(dataBlocks [][]byte, pParity, qParity []byte) ([]byte, []byte) {
+	pStar := append([]byte(nil), pParity...)
+	qStar := append([]byte(nil), qParity...)
+
+	// Recompute P* and Q* by summing the data blocks into the stored parities
+	workers.ranges(len(pStar), func(lo, hi int) {
+		for j, dataBlock := range dataBlocks {
+			if dataBlock != nil {
+				rm.XorSlice(dataBlock[lo:hi], pStar[lo:hi])
+				rm.MulSliceXor(rm.coef(1, j), dataBlock[lo:hi], qStar[lo:hi])
+			}
+		}
+	})
+
 	return pStar, qStar
 }
 
@@ -169,140 +254,261 @@ func (rm *RAIDMath) recomputeSyndromes(dataBlocks [][]byte, pParity, qParity []b
 
 // RecoverSingleBlockP Recover a single lost block using P parity
 func (rm *RAIDMath) RecoverSingleBlockP(dataBlocks [][]byte, pParity []byte, missingIndex int) []byte {
-	blockSize := len(pParity)
-	dataBlocks[missingIndex] = make([]byte, blockSize)
-
-	for i := 0; i < blockSize; i++ {
-		p := int(pParity[i])
-
-		// XOR all available blocks, excluding the missing one
-		for j := 0; j < len(dataBlocks); j++ {
-			if j != missingIndex {
-				p = rm.GfAdd(p, int(dataBlocks[j][i]))
-			}
-		}
-
-		// Write recovered block directly into the dataBlocks slice
-		dataBlocks[missingIndex][i] = byte(p)
-	}
+	p, _, _ := rm.partialSyndromes(dataBlocks, pParity, nil, nil, missingIndex)
 
+	// XOR of P and all available blocks is the missing one
+	dataBlocks[missingIndex] = p
 	return dataBlocks[missingIndex]
 }
 
 // RecoverSingleBlockQ Recover a single lost block using Q parity
 func (rm *RAIDMath) RecoverSingleBlockQ(dataBlocks [][]byte, qParity []byte, missingIndex int) []byte {
-	blockSize := len(qParity)
-
-	// Initialize the missing block if necessary
-	dataBlocks[missingIndex] = make([]byte, blockSize)
-
-	for i := 0; i < blockSize; i++ {
-		q := int(qParity[i])
-
-		// Subtract the contributions of all available blocks using Galois Field multiplication
-		for j := 0; j < len(dataBlocks); j++ {
-			if j != missingIndex {
-				q = rm.GfAdd(q, rm.GfMul(rm.GfExp(j), int(dataBlocks[j][i])))
-			}
-		}
-
-		// Recover the missing block by dividing by g^missingIndex (the generator raised to the missing block index)
-		dataBlocks[missingIndex][i] = byte(rm.GfDiv(q, rm.GfExp(missingIndex)))
-	}
+	_, q, _ := rm.partialSyndromes(dataBlocks, nil, qParity, nil, missingIndex)
 
+	// Recover the missing block by dividing by g^missingIndex (the generator raised to the missing block index)
+	rm.MulSlice(rm.inverseCoef(1, missingIndex), q, q)
+	dataBlocks[missingIndex] = q
 	return dataBlocks[missingIndex]
 }
 
 // RecoverPParity Recover P parity with dataBlocks
 func (rm *RAIDMath) RecoverPParity(dataBlocks [][]byte) (pParity []byte) {
-	blockSize := len(dataBlocks[0])
-	pParity = make([]byte, blockSize)
-
-	for i := 0; i < blockSize; i++ {
-		p := 0
-		for j := 0; j < len(dataBlocks); j++ {
-			p = rm.GfAdd(p, int(dataBlocks[j][i]))
+	pParity = make([]byte, len(dataBlocks[0]))
+	workers.ranges(len(pParity), func(lo, hi int) {
+		for _, dataBlock := range dataBlocks {
+			rm.XorSlice(dataBlock[lo:hi], pParity[lo:hi])
 		}
-
-		pParity[i] = byte(p)
-	}
+	})
 
 	return pParity
 }
 
 // RecoverQParity Recover Q parity with dataBlocks
 func (rm *RAIDMath) RecoverQParity(dataBlocks [][]byte) (qParity []byte) {
-	blockSize := len(dataBlocks[0])
-	qParity = make([]byte, blockSize)
-	for i := 0; i < blockSize; i++ {
-		q := 0
-		for j := 0; j < len(dataBlocks); j++ {
-			q = rm.GfAdd(q, rm.GfMul(rm.GfExp(j), int(dataBlocks[j][i]))) // Q uses GF multiplication with generator
+	qParity = make([]byte, len(dataBlocks[0]))
+	workers.ranges(len(qParity), func(lo, hi int) {
+		for j, dataBlock := range dataBlocks {
+			rm.MulSliceXor(rm.coef(1, j), dataBlock[lo:hi], qParity[lo:hi]) // Q uses GF multiplication with generator
 		}
-
-		qParity[i] = byte(q)
-	}
+	})
 
 	return qParity
 }
 
 // RecoverTwoDataBlocks Recover two lost blocks using P and Q parities with pParity and qParity
 func (rm *RAIDMath) RecoverTwoDataBlocks(dataBlocks [][]byte, pParity, qParity []byte, missingIndex1, missingIndex2 int) ([]byte, []byte) {
-	blockSize := len(pParity)
-
-	// Initialize missing blocks if necessary
-	dataBlocks[missingIndex1] = make([]byte, blockSize)
-	dataBlocks[missingIndex2] = make([]byte, blockSize)
-
-	for i := 0; i < blockSize; i++ {
-		// Get P and Q parities for the current byte
-		p := int((pParity)[i])
-		q := int((qParity)[i])
-
-		// Calculate the sum of known data blocks for P and Q
-		for j := 0; j < len(dataBlocks); j++ {
-			if j != missingIndex1 && j != missingIndex2 {
-				p = rm.GfAdd(p, int(dataBlocks[j][i]))
-				q = rm.GfAdd(q, rm.GfMul(rm.GfExp(j), int(dataBlocks[j][i])))
-			}
+	x := rm.GfExp(missingIndex1) // g^missingIndex1
+	y := rm.GfExp(missingIndex2) // g^missingIndex2
+	div := byte(rm.GfInverse(rm.GfAdd(x, y)))
+
+	// Byte ranges are independent, every worker solves the system for its own range
+	d1 := make([]byte, len(pParity))
+	d2 := make([]byte, len(pParity))
+	workers.ranges(len(pParity), func(lo, hi int) {
+		// Sum the known data blocks into P and Q, leaving d1 + d2 and x*d1 + y*d2
+		p, q, _ := rm.partialSyndromes(subBlocks(dataBlocks, lo, hi), pParity[lo:hi], qParity[lo:hi], nil, missingIndex1, missingIndex2)
+
+		// Solve for D2 first: d2 = (q + x*p) / (x + y)
+		rm.MulSliceXor(byte(x), p, q)
+		rm.MulSlice(div, q, d2[lo:hi])
+
+		// Then d1 = p + d2
+		rm.XorSlice(d2[lo:hi], p)
+		copy(d1[lo:hi], p)
+	})
+
+	// Write the recovered blocks
+	dataBlocks[missingIndex1] = d1
+	dataBlocks[missingIndex2] = d2
+	return dataBlocks[missingIndex1], dataBlocks[missingIndex2]
+}
+
+// RecoverPQParities Recover P and Q parities with pParity and qParity as *([]byte)
+func (rm *RAIDMath) RecoverPQParities(dataBlocks [][]byte) (pParity []byte, qParity []byte) {
+	// Recalculate both P and Q parities from scratch
+	return rm.CalculateParity(dataBlocks, len(dataBlocks[0]))
+}
+
+// DataDelta Return the change of a data block, D + D' in GF(2^8)
+func (rm *RAIDMath) DataDelta(oldData, newData []byte) []byte {
+	delta := append([]byte(nil), oldData...)
+	rm.XorSlice(newData, delta)
+	return delta
+}
+
+// UpdatePParity Apply the change of a data block to P in place: P' = P + (D + D')
+func (rm *RAIDMath) UpdatePParity(pParity, delta []byte) {
+	rm.XorSlice(delta, pParity)
+}
+
+// UpdateQParity Apply the change of data block j to Q in place: Q' = Q + g^j*(D + D')
+func (rm *RAIDMath) UpdateQParity(qParity, delta []byte, j int) {
+	rm.MulSliceXor(rm.coef(1, j), delta, qParity)
+}
+
+// ==== TRIPLE PARITY ====
+
+// CalculateTripleParity Calculate P, Q and R parities for the data blocks. R weights data block j with
+// g^2j, which is 4^j for generator 2, so any three lost blocks of a stripe can be recovered.
+func (rm *RAIDMath) CalculateTripleParity(dataBlocks [][]byte, blockSize int) ([]byte, []byte, []byte) {
+	pParity := make([]byte, blockSize)
+	qParity := make([]byte, blockSize)
+	rParity := make([]byte, blockSize)
+
+	workers.ranges(blockSize, func(lo, hi int) {
+		for j, dataBlock := range dataBlocks {
+			rm.XorSlice(dataBlock[lo:hi], pParity[lo:hi])
+			rm.MulSliceXor(rm.coef(1, j), dataBlock[lo:hi], qParity[lo:hi])
+			rm.MulSliceXor(rm.coef(2, j), dataBlock[lo:hi], rParity[lo:hi])
 		}
+	})
+	return pParity, qParity, rParity
+}
 
-		// Solve for D2 first
-		x := rm.GfExp(missingIndex1) // g^missingIndex1
-		y := rm.GfExp(missingIndex2) // g^missingIndex2
-		diff := rm.GfAdd(y, x)       // g^missingIndex2 - g^missingIndex1
+// UpdateRParity Apply the change of data block j to R in place: R' = R + g^2j*(D + D')
+func (rm *RAIDMath) UpdateRParity(rParity, delta []byte, j int) {
+	rm.MulSliceXor(rm.coef(2, j), delta, rParity)
+}
 
-		d2 := rm.GfDiv(rm.GfAdd(q, rm.GfMul(x, p)), diff)
-		d1 := rm.GfAdd(p, d2)
+// RecoverRParity Recover R parity with dataBlocks
+func (rm *RAIDMath) RecoverRParity(dataBlocks [][]byte) (rParity []byte) {
+	rParity = make([]byte, len(dataBlocks[0]))
+	workers.ranges(len(rParity), func(lo, hi int) {
+		for j, dataBlock := range dataBlocks {
+			rm.MulSliceXor(rm.coef(2, j), dataBlock[lo:hi], rParity[lo:hi]) // R uses the square of the Q coefficient
+		}
+	})
 
-		// Write the recovered blocks
-		dataBlocks[missingIndex1][i] = byte(d1)
-		dataBlocks[missingIndex2][i] = byte(d2)
-	}
-	return dataBlocks[missingIndex1], dataBlocks[missingIndex2]
+	return rParity
 }
 
-// RecoverPQParities Recover P and Q parities with pParity and qParity as *([]byte)
-func (rm *RAIDMath) RecoverPQParities(dataBlocks [][]byte) (pParity []byte, qParity []byte) {
-	blockSize := len(dataBlocks[0])
-	pParity = make([]byte, blockSize)
-	qParity = make([]byte, blockSize)
+// partialSyndromes Sum the available data blocks into copies of the stored parities, leaving only the
+// contributions of the missing blocks. Parities that are not given are returned as nil.
+func (rm *RAIDMath) partialSyndromes(dataBlocks [][]byte, pParity, qParity, rParity []byte, missing ...int) ([]byte, []byte, []byte) {
+	var p, q, r []byte
+	if len(pParity) != 0 {
+		p = append([]byte(nil), pParity...)
+	}
+	if len(qParity) != 0 {
+		q = append([]byte(nil), qParity...)
+	}
+	if len(rParity) != 0 {
+		r = append([]byte(nil), rParity...)
+	}
 
-	// Recalculate both P and Q parities from scratch
-	for i := 0; i < blockSize; i++ {
-		p := 0
-		q := 0
-
-		// Sum over all the data blocks to recalculate P and Q parities
-		for j := 0; j < len(dataBlocks); j++ {
-			p = rm.GfAdd(p, int(dataBlocks[j][i]))
-			q = rm.GfAdd(q, rm.GfMul(rm.GfExp(j), int(dataBlocks[j][i])))
+	size := max(len(p), len(q), len(r))
+	workers.ranges(size, func(lo, hi int) {
+		for j, dataBlock := range dataBlocks {
+			if containsIndex(missing, j) {
+				continue
+			}
+			if p != nil {
+				rm.XorSlice(dataBlock[lo:hi], p[lo:hi])
+			}
+			if q != nil {
+				rm.MulSliceXor(rm.coef(1, j), dataBlock[lo:hi], q[lo:hi])
+			}
+			if r != nil {
+				rm.MulSliceXor(rm.coef(2, j), dataBlock[lo:hi], r[lo:hi])
+			}
 		}
+	})
+	return p, q, r
+}
+
+// inverseCoef Inverse of the coefficient of data block j in the parity with the given power of the generator
+func (rm *RAIDMath) inverseCoef(power, j int) byte {
+	return byte(rm.GfInverse(int(rm.coef(power, j))))
+}
 
-		// Update the P and Q parities in-place
-		pParity[i] = byte(p)
-		qParity[i] = byte(q)
+func containsIndex(list []int, index int) bool {
+	for _, item := range list {
+		if item == index {
+			return true
+		}
 	}
+	return false
+}
 
-	return pParity, qParity
+// RecoverSingleBlockR Recover a single lost block using R parity
+func (rm *RAIDMath) RecoverSingleBlockR(dataBlocks [][]byte, rParity []byte, missingIndex int) []byte {
+	_, _, r := rm.partialSyndromes(dataBlocks, nil, nil, rParity, missingIndex)
+
+	// Divide by g^2z, the R coefficient of the missing block
+	rm.MulSlice(rm.inverseCoef(2, missingIndex), r, r)
+	dataBlocks[missingIndex] = r
+	return dataBlocks[missingIndex]
+}
+
+// RecoverTwoDataBlocksPR Recover two lost blocks using P and R parities, when Q is lost as well
+func (rm *RAIDMath) RecoverTwoDataBlocksPR(dataBlocks [][]byte, pParity, rParity []byte, missingIndex1, missingIndex2 int) ([]byte, []byte) {
+	p, _, r := rm.partialSyndromes(dataBlocks, pParity, nil, rParity, missingIndex1, missingIndex2)
+
+	// d1 + d2 = p and x2*d1 + y2*d2 = r, so d2 = (r + x2*p) / (x2 + y2)
+	x2 := rm.coef(2, missingIndex1) // R coefficient of the first missing block
+	y2 := rm.coef(2, missingIndex2) // R coefficient of the second missing block
+	rm.MulSliceXor(x2, p, r)
+	rm.MulSlice(byte(rm.GfInverse(int(x2^y2))), r, r)
+	d2 := r
+
+	rm.XorSlice(d2, p)
+	d1 := p
+
+	dataBlocks[missingIndex1] = d1
+	dataBlocks[missingIndex2] = d2
+	return dataBlocks[missingIndex1], dataBlocks[missingIndex2]
+}
+
+// RecoverTwoDataBlocksQR Recover two lost blocks using Q and R parities, when P is lost as well
+func (rm *RAIDMath) RecoverTwoDataBlocksQR(dataBlocks [][]byte, qParity, rParity []byte, missingIndex1, missingIndex2 int) ([]byte, []byte) {
+	_, q, r := rm.partialSyndromes(dataBlocks, nil, qParity, rParity, missingIndex1, missingIndex2)
+
+	// x*d1 + y*d2 = q and x^2*d1 + y^2*d2 = r, eliminate d1 with x*q
+	x := rm.GfExp(missingIndex1)
+	y := rm.GfExp(missingIndex2)
+	rm.MulSliceXor(byte(x), q, r)
+	rm.MulSlice(byte(rm.GfInverse(rm.GfMul(y, rm.GfAdd(x, y)))), r, r)
+	d2 := r
+
+	// Then d1 = (q + y*d2) / x
+	rm.MulSliceXor(byte(y), d2, q)
+	rm.MulSlice(byte(rm.GfInverse(x)), q, q)
+	d1 := q
+
+	dataBlocks[missingIndex1] = d1
+	dataBlocks[missingIndex2] = d2
+	return dataBlocks[missingIndex1], dataBlocks[missingIndex2]
+}
+
+// RecoverThreeDataBlocks Recover three lost blocks using P, Q and R parities
+func (rm *RAIDMath) RecoverThreeDataBlocks(dataBlocks [][]byte, pParity, qParity, rParity []byte, missingIndex1, missingIndex2, missingIndex3 int) ([]byte, []byte, []byte) {
+	p, q, r := rm.partialSyndromes(dataBlocks, pParity, qParity, rParity, missingIndex1, missingIndex2, missingIndex3)
+
+	a := rm.GfExp(missingIndex1)
+	b := rm.GfExp(missingIndex2)
+	c := rm.GfExp(missingIndex3)
+	ab := rm.GfAdd(a, b)
+	div := rm.GfMul(rm.GfAdd(a, c), rm.GfAdd(b, c)) // Non-zero as the block indices differ
+
+	// Eliminating d1 and then d2 from the Vandermonde system leaves (a+c)(b+c)*d3 = r + (a+b)*q + ab*p
+	d3 := append([]byte(nil), r...)
+	rm.MulSliceXor(byte(ab), q, d3)
+	rm.MulSliceXor(byte(rm.GfMul(a, b)), p, d3)
+	rm.MulSlice(byte(rm.GfInverse(div)), d3, d3)
+
+	// d2 = (q + a*p + (a+c)*d3) / (a+b)
+	d2 := q
+	rm.MulSliceXor(byte(a), p, d2)
+	rm.MulSliceXor(byte(rm.GfAdd(a, c)), d3, d2)
+	rm.MulSlice(byte(rm.GfInverse(ab)), d2, d2)
+
+	// d1 = p + d2 + d3
+	d1 := p
+	rm.XorSlice(d2, d1)
+	rm.XorSlice(d3, d1)
+
+	dataBlocks[missingIndex1] = d1
+	dataBlocks[missingIndex2] = d2
+	dataBlocks[missingIndex3] = d3
+	return dataBlocks[missingIndex1], dataBlocks[missingIndex2], dataBlocks[missingIndex3]
 }
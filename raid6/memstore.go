@@ -0,0 +1,161 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStoreOffline Returned by every operation of a MemStore that was taken offline
+var ErrStoreOffline = errors.New("store is offline")
+
+// MemStore Keeps every object in memory. Besides losing all data like Node.Corrupt does, it can
+// simulate a disk that stops responding and a disk that fails individual operations.
+type MemStore struct {
+	mu       sync.Mutex
+	objects  map[string]memObject
+	offline  bool
+	failNext int   // Number of upcoming operations to fail with failErr
+	failErr  error // Error injected by FailNext
+}
+
+type memObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemStore Create an empty in-memory store
+func NewMemStore() *MemStore {
+	return &MemStore{objects: make(map[string]memObject)}
+}
+
+// WithMemoryStore Keep the blocks of every node in memory instead of a directory under basePath.
+// Nothing is written to disk, so the cluster cannot be reopened with OpenRAID6.
+func WithMemoryStore() Option {
+	return func(r *RAID6) {
+		r.newStore = func(diskPath string) BlockStore {
+			return NewMemStore()
+		}
+	}
+}
+
+// Wipe Erase every object, like a disk that was replaced
+func (s *MemStore) Wipe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects = make(map[string]memObject)
+}
+
+// SetOffline Make every operation fail with ErrStoreOffline until the store is brought back online.
+// The stored objects are kept. A cluster writing to the store takes its node out of service, the
+// node is brought back by recovering it.
+func (s *MemStore) SetOffline(offline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.offline = offline
+}
+
+// FailNext Make the next n operations fail with err, n = 0 clears pending failures
+func (s *MemStore) FailNext(n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failNext = n
+	s.failErr = err
+}
+
+// check Return the simulated failure of the next operation, if any. Must hold s.mu.
+func (s *MemStore) check(op, key string) error {
+	if s.offline {
+		return fmt.Errorf("%s %s: %w", op, key, ErrStoreOffline)
+	}
+	if s.failNext > 0 {
+		s.failNext--
+		return fmt.Errorf("%s %s: %w", op, key, s.failErr)
+	}
+	return nil
+}
+
+func (s *MemStore) Put(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check("put", key); err != nil {
+		return err
+	}
+	s.objects[key] = memObject{data: append([]byte(nil), data...), modTime: time.Now()}
+	return nil
+}
+
+func (s *MemStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check("get", key); err != nil {
+		return nil, err
+	}
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("get %s: %w", key, fs.ErrNotExist)
+	}
+	return append([]byte(nil), obj.data...), nil // Callers may modify the returned data
+}
+
+func (s *MemStore) Has(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check("has", key); err != nil {
+		return false, err
+	}
+	_, ok := s.objects[key]
+	return ok, nil
+}
+
+func (s *MemStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check("delete", key); err != nil {
+		return err
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemStore) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check("list", prefix); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemStore) Stat(key string) (BlockInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check("stat", key); err != nil {
+		return BlockInfo{}, err
+	}
+	obj, ok := s.objects[key]
+	if !ok {
+		return BlockInfo{}, fmt.Errorf("stat %s: %w", key, fs.ErrNotExist)
+	}
+	return BlockInfo{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
@@ -0,0 +1,130 @@
+package raid6
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FileMeta Per-file metadata stored redundantly on every node next to the blocks
+type FileMeta struct {
+	FileName  string    `json:"file_name"`
+	FileID    uint64    `json:"file_id"`    // Random ID stored in every block header of the file
+	Size      int       `json:"size"`       // Original file length in bytes, without padding
+	BlockSize int       `json:"block_size"` // Size of every data and parity block
+	DataDisks int       `json:"data_disks"` // Number of data blocks in each stripe
+	Stripes   int       `json:"stripes"`    // Number of stripes the file is split into
+	Rotation  int       `json:"rotation"`   // Position in Nodes holding the P parity of stripe 0, later stripes rotate from there
+	Nodes     []int     `json:"nodes"`      // Nodes the file is striped across, DataDisks plus one per parity
+	Layout    int       `json:"layout"`     // Layout version, incremented every time the file is restriped
+	Version   int       `json:"version"`    // Content version, incremented by every update
+	Epoch     int       `json:"epoch"`      // Membership epoch the IDs in Nodes refer to, renewed when a decommission renumbers them
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func InitFileMeta(fileName string, size, blockSize, dataDisks, stripes int) *FileMeta {
+	return &FileMeta{
+		FileName:  fileName,
+		Size:      size,
+		BlockSize: blockSize,
+		DataDisks: dataDisks,
+		Stripes:   stripes,
+		CreatedAt: time.Now(),
+	}
+}
+
+// onNodes Check if the file is striped across any of the given nodes
+func (meta *FileMeta) onNodes(nodeIDs ...int) bool {
+	for _, n := range meta.Nodes {
+		for _, nodeID := range nodeIDs {
+			if n == nodeID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// metaSuffix Follows the encoded file name in the key of a metadata record
+const metaSuffix = ".meta"
+
+// metaKey Key of the metadata record of a file in a node's store
+func metaKey(fileName string) string {
+	return encodeFileName(fileName) + metaSuffix
+}
+
+// WriteMetaToDisk writes the metadata record of a file to the node
+func (n *Node) WriteMetaToDisk(meta *FileMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return n.Store.Put(metaKey(meta.FileName), data)
+}
+
+// ReadMetaFromDisk reads the metadata record of a file from the node
+func (n *Node) ReadMetaFromDisk(fileName string) (*FileMeta, error) {
+	data, err := n.Store.Get(metaKey(fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeFileMeta(fileName, data)
+}
+
+func decodeFileMeta(fileName string, data []byte) (*FileMeta, error) {
+	meta := &FileMeta{}
+	err := json.Unmarshal(data, meta)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata for file %s: %w", fileName, err)
+	}
+	return meta, nil
+}
+
+// writeFileMeta Write the metadata record to every active node
+func (r *RAID6) writeFileMeta(meta *FileMeta) error {
+	for _, node := range r.Nodes {
+		if !node.writable() {
+			continue
+		}
+		err := node.WriteMetaToDisk(meta)
+		if err != nil && !r.dropOffline(node, err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getFileMeta Read the metadata record from the active nodes and return the copy most of them agree on
+func (r *RAID6) getFileMeta(fileName string) (*FileMeta, error) {
+	var best *FileMeta
+	bestVotes := 0
+	votes := make(map[string]int)
+
+	for _, node := range r.Nodes {
+		if !node.status {
+			continue
+		}
+		data, err := node.Store.Get(metaKey(fileName))
+		if err != nil {
+			continue // Missing copy, rely on the other nodes
+		}
+		meta, err := decodeFileMeta(fileName, data)
+		if err != nil {
+			continue // Damaged copy, rely on the other nodes
+		}
+
+		votes[string(data)]++
+		if votes[string(data)] > bestVotes {
+			best = meta
+			bestVotes = votes[string(data)]
+		}
+	}
+
+	if best == nil {
+		return nil, errors.New("file metadata not found")
+	}
+	return best, nil
+}
@@ -1,92 +1,155 @@
 package raid6
 
 import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
 	"fmt"
 	"os"
-	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
+// blockFilePattern Matches block file names: <encoded fileName>_<layout>_<stripe>_<blockID>.bin
+var blockFilePattern = regexp.MustCompile(`^([0-9A-V]+|h[0-9a-f]{64})_(\d+)_(\d+)_(-?\d+)\.bin$`)
+
+// fileNameEncoding Reversible encoding of file names on disk. The alphabet has no '_', '/', '.' or
+// glob characters, so any UTF-8 key maps to a single path component that cannot be a prefix of another.
+var fileNameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+const (
+	// maxEncodedName Longest encoding used as is. Longer names are stored under their hash, so a key
+	// with its block suffix and temp file prefix stays within the 255 byte limit of a path component.
+	maxEncodedName = 128
+	// hashedNamePrefix Starts the key of a hashed name, lower case letters are not in the encoding alphabet
+	hashedNamePrefix = "h"
+)
+
+func encodeFileName(fileName string) string {
+	encoded := fileNameEncoding.EncodeToString([]byte(fileName))
+	if len(encoded) <= maxEncodedName {
+		return encoded
+	}
+	sum := sha256.Sum256([]byte(fileName))
+	return hashedNamePrefix + hex.EncodeToString(sum[:])
+}
+
+func decodeFileName(encoded string) (string, error) {
+	fileName, err := fileNameEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encoded file name %s: %w", encoded, err)
+	}
+	return string(fileName), nil
+}
+
+// lookupFileName Return the name of the file stored under an encoded name. A hashed name cannot be
+// decoded, the metadata record of the file, which holds the full name, serves as its name index.
+func (n *Node) lookupFileName(encoded string) (string, error) {
+	if !strings.HasPrefix(encoded, hashedNamePrefix) {
+		return decodeFileName(encoded)
+	}
+
+	data, err := n.Store.Get(encoded + metaSuffix)
+	if err != nil {
+		return "", err
+	}
+	meta, err := decodeFileMeta(encoded, data)
+	if err != nil {
+		return "", err
+	}
+	if encodeFileName(meta.FileName) != encoded {
+		return "", fmt.Errorf("metadata of %s names another file", encoded)
+	}
+	return meta.FileName, nil
+}
+
 type Block struct {
 	FileName string
+	FileID   uint64
+	Layout   int // Layout version of the file the block belongs to
 	Data     *[]byte
 	BlockID  int
+	Stripe   int
 	Size     int
 }
 
 type Node struct {
-	NodeID   int
-	status   bool // true for active, false for inactive(failure)
-	DiskPath string
+	NodeID     int
+	DiskID     string // Unique ID of the disk, assigned when the disk is formatted
+	status     bool   // true for active, false for inactive(failure)
+	rebuilding bool   // true while a rebuild job restores the node, writes go to it but reads do not
+	retiring   bool   // true while the node is decommissioned, new files are no longer striped across it
+	DiskPath   string
+	Store      BlockStore // Backend holding the node's blocks, a DirStore on DiskPath by default
 }
 
-func InitBlock(blockID int, fileName string, data *[]byte, blockSize int) *Block {
+// writable Check if new blocks should be written to the node
+func (n *Node) writable() bool {
+	return n.status || n.rebuilding
+}
+
+func InitBlock(blockID, stripe int, fileName string, data *[]byte, blockSize int) *Block {
 	return &Block{
 		FileName: fileName,
 		Data:     data,
 		BlockID:  blockID, // -1 for P parity, -2 for Q parity
+		Stripe:   stripe,
 		Size:     blockSize,
 	}
 }
 
-func (n *Node) getBlockFilePath(fileName string, blockID int) string {
-	return fmt.Sprintf("%s/%s_%d.bin", n.DiskPath, fileName, blockID)
+// blockKey Key of a block in the node's store
+func blockKey(fileName string, layout, stripe, blockID int) string {
+	return fmt.Sprintf("%s_%d_%d_%d.bin", encodeFileName(fileName), layout, stripe, blockID)
 }
 
-func (n *Node) CheckBlockExists(fileName string, blockID int) bool {
-	filePath := n.getBlockFilePath(fileName, blockID)
-	_, err := os.Stat(filePath)
-	if err == nil {
-		return true
-	}
-	if os.IsNotExist(err) {
+func (n *Node) CheckBlockExists(fileName string, layout, stripe, blockID int) bool {
+	exists, err := n.Store.Has(blockKey(fileName, layout, stripe, blockID))
+	if err != nil {
+		// Some other error occurred (e.g., permission denied)
 		return false
 	}
-
-	// Some other error occurred (e.g., permission denied)
-	return false
+	return exists
 }
 
 func (n *Node) CheckFileExists(fileName string) (bool, error) {
-	pattern := filepath.Join(n.DiskPath, fileName+"*")
-
-	// Find files matching the pattern
-	matches, err := filepath.Glob(pattern)
+	// Find blocks of the file, the encoded name is never a prefix of another
+	keys, err := n.Store.List(encodeFileName(fileName) + "_")
 	if err != nil {
 		return false, err
 	}
 
-	if len(matches) == 0 {
-		return false, nil
-	} else {
-		return true, nil
+	for _, key := range keys {
+		if blockFilePattern.MatchString(key) {
+			return true, nil
+		}
 	}
+	return false, nil
 }
 
 func (n *Node) ScanFileNames() ([]string, error) {
-	pattern := regexp.MustCompile(`^(.+?)_([^_]+)\.bin$`)
+	pattern := blockFilePattern
 	fileNames := []string{}
+	seen := make(map[string]bool)
 
-	// Find files matching the pattern
-	entries, err := os.ReadDir(n.DiskPath)
+	// Find blocks stored on the node
+	keys, err := n.Store.List("")
 	if err != nil {
-		return fileNames, fmt.Errorf("failed to read directory: %w", err)
+		return fileNames, fmt.Errorf("failed to list blocks: %w", err)
 	}
 
-	// Iterate over each entry
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue // Skip directories
-		}
-
-		// Get the filename
-		name := entry.Name()
-
-		// Match the filename against the pattern
-		matches := pattern.FindStringSubmatch(name)
-		if len(matches) == 3 {
-			// Extract fileName (group 1)
-			fileName := matches[1]
+	// Iterate over each key
+	for _, key := range keys {
+		// Match the key against the pattern
+		matches := pattern.FindStringSubmatch(key)
+		if len(matches) == 5 && !seen[matches[1]] {
+			seen[matches[1]] = true
+			// Extract and decode fileName (group 1)
+			fileName, err := n.lookupFileName(matches[1])
+			if err != nil {
+				continue // Not a block written by this system, or its metadata is gone
+			}
 			fileNames = append(fileNames, fileName)
 		}
 	}
@@ -94,48 +157,76 @@ func (n *Node) ScanFileNames() ([]string, error) {
 	return fileNames, nil
 }
 
-// ReadBlockFromDisk reads a block's data based on block ID
-func (n *Node) ReadBlockFromDisk(fileName string, blockID int) ([]byte, error) {
-	filePath := n.getBlockFilePath(fileName, blockID)
-	file, err := os.Open(filePath)
+// ReadBlockFromDisk reads a block's data based on layout version, stripe and block ID.
+// The header checksum is verified and must name the requested block of file fileID, otherwise
+// an error wrapping ErrChecksumMismatch is returned.
+func (n *Node) ReadBlockFromDisk(fileName string, layout, stripe, blockID int, fileID uint64) ([]byte, error) {
+	key := blockKey(fileName, layout, stripe, blockID)
+	buf, err := n.Store.Get(key)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	fileInfo, _ := file.Stat()
-	blockData := make([]byte, fileInfo.Size())
-	_, err = file.Read(blockData)
+	header, blockData, err := decodeBlock(buf)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("block %s: %w", key, err)
+	}
+	if header.BlockID != blockID || header.Stripe != stripe || header.FileID != fileID {
+		return nil, fmt.Errorf("block %s: %w: header belongs to another block", key, ErrChecksumMismatch)
 	}
 
 	return blockData, nil
 }
 
-// WriteBlockToDisk writes data to a block based on block ID and file name
+// WriteBlockToDisk writes data to a block based on block ID and file name, atomically replacing any previous copy
 func (n *Node) WriteBlockToDisk(b *Block) error {
-	filePath := n.getBlockFilePath(b.FileName, b.BlockID)
+	return n.Store.Put(blockKey(b.FileName, b.Layout, b.Stripe, b.BlockID), encodeBlock(b))
+}
 
-	// Remove the old file if it exists
-	err := os.Remove(filePath)
-	if err != nil && !os.IsNotExist(err) {
-		return err // Return error if removal fails for reasons other than the file not existing
-	}
+// DeleteBlockFromDisk removes a block based on layout version, stripe and block ID, missing blocks are ignored
+func (n *Node) DeleteBlockFromDisk(fileName string, layout, stripe, blockID int) error {
+	return n.Store.Delete(blockKey(fileName, layout, stripe, blockID))
+}
 
-	// Open file with read/write permission. Create it if it does not exist.
-	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE, 0644)
+// DeleteLayoutFromDisk removes every block of one layout version of a file from the node
+func (n *Node) DeleteLayoutFromDisk(fileName string, layout int) error {
+	keys, err := n.Store.List(fmt.Sprintf("%s_%d_", encodeFileName(fileName), layout))
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to list blocks: %w", err)
 	}
-	defer file.Close()
 
-	_, err = file.Write(*b.Data)
+	for _, key := range keys {
+		matches := blockFilePattern.FindStringSubmatch(key)
+		if len(matches) != 5 || matches[1] != encodeFileName(fileName) || matches[2] != strconv.Itoa(layout) {
+			continue
+		}
+		err = n.Store.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteFileFromDisk removes every block and the metadata of a file from the node
+func (n *Node) DeleteFileFromDisk(fileName string) error {
+	keys, err := n.Store.List(encodeFileName(fileName) + "_")
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to list blocks: %w", err)
 	}
 
-	return nil
+	for _, key := range keys {
+		matches := blockFilePattern.FindStringSubmatch(key)
+		if len(matches) != 5 || matches[1] != encodeFileName(fileName) {
+			continue
+		}
+		err = n.Store.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return n.Store.Delete(metaKey(fileName))
 }
 
 func InitNode(nodeID int, diskPath string) *Node {
@@ -144,21 +235,30 @@ func InitNode(nodeID int, diskPath string) *Node {
 		os.MkdirAll(diskPath, os.ModePerm)
 	}
 
+	return NewNode(nodeID, diskPath, NewDirStore(diskPath))
+}
+
+// NewNode Create a node keeping its blocks in the given store, diskPath only names the node
+func NewNode(nodeID int, diskPath string, store BlockStore) *Node {
 	return &Node{
 		NodeID:   nodeID,
+		DiskID:   newRandomID(),
 		status:   true,
 		DiskPath: diskPath,
+		Store:    store,
 	}
 }
 
+// Corrupt Simulate a lost disk: the node fails and everything it stored is erased
 func (n *Node) Corrupt() error {
 	n.status = false
-	entries, err := os.ReadDir(n.DiskPath)
+	n.rebuilding = false
+	keys, err := n.Store.List("")
 	if err != nil {
 		return err
 	}
-	for _, entry := range entries {
-		err = os.Remove(filepath.Join(n.DiskPath, entry.Name()))
+	for _, key := range keys {
+		err = n.Store.Delete(key)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,101 @@
+package raid6
+
+import (
+	"runtime"
+	"sync"
+)
+
+// minRangeSize Smallest byte range handed to a worker, shorter blocks are not worth splitting
+const minRangeSize = 16 * 1024
+
+// workerPool Bounds the goroutines doing parity math and block I/O across the whole process. A task
+// only gets its own goroutine while a slot is free and runs on the calling goroutine otherwise, so
+// nested parallel loops (stripes, then byte ranges) cannot deadlock waiting for each other.
+type workerPool struct {
+	slots chan struct{}
+}
+
+// workers Shared pool sized to GOMAXPROCS
+var workers = newWorkerPool(runtime.GOMAXPROCS(0))
+
+// SetParallelism Resize the shared pool to n workers, GOMAXPROCS by default. Must not be called while
+// a cluster is in use.
+func SetParallelism(n int) {
+	workers = newWorkerPool(n)
+}
+
+func newWorkerPool(size int) *workerPool {
+	if size < 1 {
+		size = 1
+	}
+	return &workerPool{slots: make(chan struct{}, size)}
+}
+
+// run Call fn for every index in [0, n) and wait for all calls to return
+func (p *workerPool) run(n int, fn func(i int)) {
+	if n == 1 {
+		fn(0)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case p.slots <- struct{}{}:
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-p.slots }()
+				fn(i)
+			}(i)
+		default:
+			fn(i) // Pool is busy, do the work here
+		}
+	}
+	wg.Wait()
+}
+
+// runErr Call fn for every index in [0, n) and return the error of the lowest failing index
+func (p *workerPool) runErr(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	p.run(n, func(i int) {
+		errs[i] = fn(i)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ranges Split size bytes into at most one range per worker, each a multiple of 8 bytes so the
+// word-wide XOR kernel is not cut short, and call fn for every range
+func (p *workerPool) ranges(size int, fn func(lo, hi int)) {
+	n := min(cap(p.slots), size/minRangeSize)
+	if n <= 1 {
+		fn(0, size)
+		return
+	}
+
+	// Round the share of each worker up, the last range takes whatever is left
+	step := ((size+n-1)/n + 7) &^ 7
+	p.run(n, func(i int) {
+		lo := i * step
+		hi := min(lo+step, size)
+		if lo < hi {
+			fn(lo, hi)
+		}
+	})
+}
+
+// subBlocks Return views of bytes [lo, hi) of every block, missing blocks stay empty
+func subBlocks(blocks [][]byte, lo, hi int) [][]byte {
+	sub := make([][]byte, len(blocks))
+	for i, block := range blocks {
+		if len(block) != 0 {
+			sub[i] = block[lo:hi]
+		}
+	}
+	return sub
+}
@@ -4,146 +4,222 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"math/rand"
 	"sync"
-	"time"
 )
 
+// ErrFileExists Returned when writing a file whose name is already taken and Overwrite is off
+var ErrFileExists = errors.New("file already exists")
+
 type RAID6 struct {
-	Nodes     []*Node
-	Math      *RAIDMath
-	FileNum   int
-	FileNames []string
-	DiskNum   int
+	ClusterID     string
+	Epoch         int // Membership epoch, see Superblock
+	Nodes         []*Node
+	Spares        []*Node // Hot spares promoted in place of failed nodes
+	Math          *RAIDMath
+	Code          ErasureCode // Erasure code protecting every stripe, P and Q parity by default
+	FileNum       int
+	FileNames     []string
+	DiskNum       int
+	ChunkSize     int                        // Block size of a full stripe, applies to files written afterwards
+	Overwrite     bool                       // Replace existing files on write instead of failing with ErrFileExists
+	RebuildRate   int64                      // I/O limit in bytes per second of rebuilds started by the cluster, 0 for unlimited
+	RestripeRate  int64                      // I/O limit in bytes per second of restripes started by the cluster, 0 for unlimited
+	newStore      storeFactory               // Backend of the nodes created by the cluster, nil for directories
+	creating      map[string]bool            // Files being streamed in by Create, not yet in the catalog
+	rebuilds      []*RebuildJob              // Rebuilds started by the cluster that are still running
+	restripes     []*RestripeJob             // Restripes started by the cluster that are still running
+	restriping    map[string]*restripeTarget // Files being moved by a restripe job, by name
+	failMu        sync.Mutex                 // Serializes nodes dropped by concurrent block writes
+	dropped       []*Node                    // Nodes dropped by block writes, replaced by hot spares once the write returns
+	intentPending bool                       // A journaled write failed partway, its intent is finished before the next one
 	sync.Mutex
 }
 
-func InitRAID6(numDisks int, basePath string) *RAID6 {
+// InitRAID6 Create a cluster of numDisks nodes under basePath, protected with P and Q parity unless
+// an option picks another code. Fails if the stripes would have no data block or not fit the field of the code.
+func InitRAID6(numDisks int, basePath string, opts ...Option) (*RAID6, error) {
 	raid := &RAID6{
-		DiskNum:   numDisks,
-		Nodes:     make([]*Node, numDisks), // 6 data nodes, 2 parity nodes
-		Math:      NewRAIDMath(2),          // Generator 2 for GF(2^8)
-		FileNames: make([]string, 0),
-		FileNum:   0, // no file at the beginning
+		ClusterID:  newRandomID(),
+		DiskNum:    numDisks,
+		Nodes:      make([]*Node, numDisks), // 6 data nodes, 2 parity nodes
+		Math:       NewRAIDMath(2),          // Generator 2 for GF(2^8)
+		FileNames:  make([]string, 0),
+		FileNum:    0, // no file at the beginning
+		ChunkSize:  DefaultChunkSize,
+		creating:   make(map[string]bool),
+		restriping: make(map[string]*restripeTarget),
+	}
+	raid.Code = newPQCode(raid.Math)
+	for _, opt := range opts {
+		opt(raid)
+	}
+	err := checkStripeWidth(raid.Code, raid.DiskNum)
+	if err != nil {
+		return nil, err
 	}
 
 	for i := 0; i < raid.DiskNum; i++ {
 		diskPath := fmt.Sprintf("%s/disk_%d", basePath, i)
-		raid.Nodes[i] = InitNode(i, diskPath)
+		raid.Nodes[i] = raid.initNode(i, diskPath)
 	}
 
-	return raid
+	// Stamp every disk so the cluster can be reopened with OpenRAID6
+	for _, node := range raid.Nodes {
+		if err := raid.formatNode(node); err != nil {
+			node.status = false
+		}
+	}
+
+	return raid, nil
 }
 
-func (r *RAID6) ScanFileNames() (err error) {
-	r.FileNames, err = r.Nodes[0].ScanFileNames()
-	if err != nil {
-		return err
+// initNode Create a node on the cluster's storage backend
+func (r *RAID6) initNode(nodeID int, diskPath string) *Node {
+	if r.newStore == nil {
+		return InitNode(nodeID, diskPath)
 	}
-	r.FileNum = len(r.FileNames)
-	return nil
+	return NewNode(nodeID, diskPath, r.newStore(diskPath))
 }
 
-// WriteFile Splits input data into blocks, calculates parity blocks and writes them to nodes.
+// WriteFile Splits input data into stripes of blocks, calculates parity blocks and writes them to nodes.
+// With Overwrite on, an existing file of the same name is replaced only once the new content is written.
 func (r *RAID6) WriteFile(fileName string, data []byte) error {
 	r.Lock()
 	defer r.Unlock()
+	defer r.promoteDropped()
 
 	if len(data) == 0 {
 		return errors.New("file data is empty")
 	}
+	oldMeta, err := r.claimFileName(fileName)
+	if err != nil {
+		return err
+	}
+	meta, err := r.planReplacement(fileName, len(data), oldMeta)
+	if err != nil {
+		return err
+	}
+	return r.writeLayout(meta, oldMeta, data)
+}
 
-	// Randomly select two indices for P and Q parity
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano())) // Seed the random number generator
-	parityIndices := rnd.Perm(r.DiskNum)[:2]               // Randomly pick two unique indices from the range of FileNum
+// writeLayout Write the data as the layout planned in meta, then switch to it with commitFile
+func (r *RAID6) writeLayout(meta, oldMeta *FileMeta, data []byte) error {
+	// Stripes are encoded and written by the worker pool, the metadata only once all of them landed
+	err := workers.runErr(meta.Stripes, func(stripe int) error {
+		return r.writeStripe(meta, stripe, splitStripe(meta, data, stripe))
+	})
+	if err != nil {
+		r.deleteLayout(meta.FileName, meta.Layout) // Best effort, the previous file is still intact
+		return err
+	}
 
-	// Assign P and Q parity dataBlocks to the randomly selected indices
-	pIndex := parityIndices[0]
-	qIndex := parityIndices[1]
+	// Record the exact length so padding can be stripped on read
+	return r.commitFile(meta, oldMeta)
+}
 
-	// Number of data disks (excluding the parity disks)
-	numDataBlocks := r.DiskNum - 2         // 2 disks for P and Q parity
-	blockSize := len(data) / numDataBlocks // Block size with rounding up for padding
-	if len(data)%numDataBlocks != 0 {
-		blockSize++
+// claimFileName Make sure a new file can take the name. If the name is taken and Overwrite is on,
+// the metadata of the file to replace is returned, the file itself is left in place.
+func (r *RAID6) claimFileName(fileName string) (*FileMeta, error) {
+	if fileName == "" {
+		return nil, errors.New("file name is empty")
 	}
-
-	dataBlocks := make([][]byte, numDataBlocks)
-	for i := 0; i < r.DiskNum-2; i++ {
-		dataBlocks[i] = make([]byte, blockSize)
-		start := i * blockSize
-		end := start + blockSize
-		if end > len(data) {
-			end = len(data)
-		}
-		if start < end {
-			copy(dataBlocks[i], data[start:end])
-		}
+	if r.creating[fileName] {
+		return nil, ErrFileExists
+	}
+	if !r.fileExists(fileName) {
+		return nil, nil
+	}
+	if !r.Overwrite {
+		return nil, ErrFileExists
 	}
 
-	// Write parity blocks into nodes
-	pParity, qParity := r.Math.CalculateParity(dataBlocks, blockSize)
-	pBlock := InitBlock(-1, fileName, &pParity, blockSize)
-	err := r.Nodes[pIndex].WriteBlockToDisk(pBlock)
+	oldMeta, err := r.getFileMeta(fileName)
 	if err != nil {
-		return err
+		// The previous file is unreadable anyway, clean it up so no stale block is left behind
+		return nil, r.deleteFile(fileName)
+	}
+	return oldMeta, nil
+}
+
+// planReplacement Plan the layout of a new file. A file replacing oldMeta gets the next layout version,
+// so the old blocks stay readable until the metadata is switched by commitFile.
+func (r *RAID6) planReplacement(fileName string, size int, oldMeta *FileMeta) (*FileMeta, error) {
+	meta := r.newFileMeta(fileName, size)
+	if oldMeta == nil {
+		return meta, nil
+	}
+	meta.Layout = oldMeta.Layout + 1
+	if t := r.restriping[fileName]; t != nil && t.meta.Layout >= meta.Layout {
+		meta.Layout = t.meta.Layout + 1 // Taken by the restripe moving the file
 	}
-	qBlock := InitBlock(-2, fileName, &qParity, blockSize)
-	err = r.Nodes[qIndex].WriteBlockToDisk(qBlock)
+
+	// Drop whatever an interrupted overwrite left of the new layout
+	return meta, r.deleteLayout(fileName, meta.Layout)
+}
+
+// commitFile Write the metadata of a fully written file, making it current, then remove the blocks of
+// the file it replaces or add it to the catalog
+func (r *RAID6) commitFile(meta, oldMeta *FileMeta) error {
+	r.touchRestripe(meta.FileName, false)
+	err := r.writeFileMeta(meta)
 	if err != nil {
 		return err
 	}
 
-	// Write data blocks into nodes
-	nodeID := 0
-	for i, dataBlock := range dataBlocks {
-		for nodeID == pIndex || nodeID == qIndex {
-			nodeID++
-		}
-		block := InitBlock(i, fileName, &dataBlock, blockSize)
-		err = r.Nodes[nodeID].WriteBlockToDisk(block)
-		if err != nil {
-			return err
-		}
-		nodeID++
+	if oldMeta != nil {
+		return r.deleteLayout(meta.FileName, oldMeta.Layout)
 	}
-
 	r.FileNum++
-	r.FileNames = append(r.FileNames, fileName)
+	r.FileNames = append(r.FileNames, meta.FileName)
 	return nil
 }
 
+// fileExists Check if the file is in the catalog
+func (r *RAID6) fileExists(fileName string) bool {
+	for _, name := range r.FileNames {
+		if name == fileName {
+			return true
+		}
+	}
+	return false
+}
+
 // ReadFile Read the file data from the RAID 6 by file name
 func (r *RAID6) ReadFile(fileName string) ([]byte, error) {
 	r.Lock()
 	defer r.Unlock()
 
-	exist := false
-	for _, name := range r.FileNames {
-		if name == fileName {
-			exist = true
-			break
-		}
-	}
-	if !exist {
+	if !r.fileExists(fileName) {
 		return nil, errors.New("file does not exist")
 	}
-	// Get the data blocks, P parity, and Q parity for the current file (index i)
-	dataBlocks, _, _ := r.GetDataBlocks(fileName)
-
-	if !r.CheckStatus() {
-		return nil, errors.New("node failure")
+	meta, err := r.getFileMeta(fileName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Concatenate the data blocks to recover the original file data
-	fileData := make([]byte, 0)
-
-	for i := 0; i < len(dataBlocks); i++ {
-		if dataBlocks[i] != nil {
-			fileData = append(fileData, dataBlocks[i]...)
+	// Stripes are read concurrently, each into its own slot of the file data
+	stripeSize := meta.BlockSize * meta.DataDisks
+	fileData := make([]byte, meta.Stripes*stripeSize)
+	err = workers.runErr(meta.Stripes, func(stripe int) error {
+		// Data blocks lost to failed nodes are rebuilt in memory, nothing is written back
+		dataBlocks, err := r.readStripeData(meta, stripe)
+		if err != nil {
+			return err
 		}
+		offset := stripe * stripeSize
+		for _, dataBlock := range dataBlocks {
+			offset += copy(fileData[offset:offset+meta.BlockSize], dataBlock)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	fileData = bytes.TrimRight(fileData, "\x00") // Remove padding
+
+	if len(fileData) < meta.Size {
+		return nil, fmt.Errorf("file %s is truncated: expected %d bytes, found %d", fileName, meta.Size, len(fileData))
+	}
+	fileData = fileData[:meta.Size] // Remove padding
 
 	return fileData, nil
 }
@@ -158,108 +234,88 @@ func (r *RAID6) CheckStatus() bool {
 	return true
 }
 
-// UpdateFile Update the file content given file name and updated data
+// UpdateFile Update the file content given file name and updated data, only stripes whose content changed are rewritten.
+// The update is atomic: changes that fit one journal record are journaled and written in place, larger
+// ones and changes of the stripe shape are written as a new layout version.
 func (r *RAID6) UpdateFile(fileName string, data []byte) error {
 	r.Lock()
 	defer r.Unlock()
+	defer r.promoteDropped()
 
 	if len(data) == 0 {
 		return errors.New("file data is empty")
 	}
-
-	exist := false
-	for _, name := range r.FileNames {
-		if name == fileName {
-			exist = true
-			break
-		}
-	}
-	if !exist {
+	if !r.fileExists(fileName) {
 		return errors.New("file does not exist")
 	}
+	if r.creating[fileName] {
+		return errors.New("file is being replaced")
+	}
+	err := r.finishIntent()
+	if err != nil {
+		return err
+	}
 
-	numDataBlocks := r.DiskNum - 2
-	blockSize := len(data) / numDataBlocks
-	if len(data)%numDataBlocks != 0 {
-		blockSize++
+	oldMeta, err := r.getFileMeta(fileName)
+	if err != nil {
+		return err
 	}
 
-	dataBlocks := make([][]byte, r.DiskNum-2)
-	for i := 0; i < r.DiskNum-2; i++ {
-		dataBlocks[i] = make([]byte, blockSize)
-		start := i * blockSize
-		end := start + blockSize
-		if end > len(data) {
-			end = len(data)
-		}
-		if start < end {
-			copy(dataBlocks[i], data[start:end])
-		}
+	// Keep the creation time and parity placement, the length and stripes follow the new content
+	meta := r.planFileMeta(fileName, len(data), oldMeta.Nodes)
+	meta.CreatedAt = oldMeta.CreatedAt
+	meta.Rotation = oldMeta.Rotation
+	meta.FileID = oldMeta.FileID
+	meta.Layout = oldMeta.Layout
+	meta.Version = oldMeta.Version + 1
+	meta.Epoch = oldMeta.Epoch
+	if meta.BlockSize != oldMeta.BlockSize || meta.DataDisks != oldMeta.DataDisks {
+		return r.replaceFile(oldMeta, data)
 	}
 
-	// Write parity blocks into nodes
-	pParity, qParity := r.Math.CalculateParity(dataBlocks, blockSize)
-	pBlock := InitBlock(-1, fileName, &pParity, blockSize)
-	qBlock := InitBlock(-2, fileName, &qParity, blockSize)
-	for _, node := range r.Nodes {
-		if node.CheckBlockExists(fileName, -2) {
-			err := node.WriteBlockToDisk(qBlock)
-			if err != nil {
-				return err
-			}
-		} else if node.CheckBlockExists(fileName, -1) {
-			err := node.WriteBlockToDisk(pBlock)
-			if err != nil {
-				return err
-			}
+	// Journal the changed stripes before touching any of their blocks, a crash from here on is
+	// finished by replayIntent, which also switches the metadata
+	intent := &Intent{Meta: meta, OldStripes: oldMeta.Stripes, Final: true}
+	journaled := 0
+	for stripe := 0; stripe < meta.Stripes; stripe++ {
+		dataBlocks := splitStripe(meta, data, stripe)
+		if stripe < oldMeta.Stripes && r.stripeUnchanged(oldMeta, stripe, dataBlocks) {
+			continue
 		}
-		for j := 0; j < r.DiskNum-2; j++ {
-			if node.CheckBlockExists(fileName, j) {
-				err := node.WriteBlockToDisk(InitBlock(j, fileName, &dataBlocks[j], blockSize))
-				if err != nil {
-					return err
-				}
-			}
+		journaled += meta.BlockSize * meta.DataDisks
+		if journaled > maxIntentSize {
+			return r.replaceFile(oldMeta, data) // Too large for one record, the old layout stays until the switch
 		}
+		intent.Stripes = append(intent.Stripes, IntentStripe{Stripe: stripe, DataBlocks: dataBlocks})
 	}
-
-	return nil
+	return r.journal(intent)
 }
 
-// GetDataBlocks Get data blocks from nodes
-func (r *RAID6) GetDataBlocks(fileName string) (dataBlocks [][]byte, P []byte, Q []byte) {
-	dataBlocks = make([][]byte, r.DiskNum-2) // Initialize dataBlocks for n-2 data disks
-	P = []byte{}                             // Initialize P as empty byte slice
-	Q = []byte{}                             // Initialize Q as empty byte slice
-
-	var pFound, qFound bool
-	for nodeID := 0; nodeID < r.DiskNum; nodeID++ {
-		node := r.Nodes[nodeID]
-
-		// Check for Parity P (-1)
-		if !pFound && node.CheckBlockExists(fileName, -1) {
-			P, _ = node.ReadBlockFromDisk(fileName, -1)
-			pFound = true
-			continue
-		}
-
-		// Check for Parity Q (-2)
-		if !qFound && node.CheckBlockExists(fileName, -2) {
-			Q, _ = node.ReadBlockFromDisk(fileName, -2)
-			qFound = true
-			continue
-		}
+// replaceFile Write the new content of a file whose stripes change shape as the next layout version,
+// the way WriteFile overwrites a file. The old layout is left untouched until the metadata is switched,
+// so no journal is needed.
+func (r *RAID6) replaceFile(oldMeta *FileMeta, data []byte) error {
+	meta, err := r.planReplacement(oldMeta.FileName, len(data), oldMeta)
+	if err != nil {
+		return err
+	}
+	meta.CreatedAt = oldMeta.CreatedAt
+	meta.Version = oldMeta.Version + 1
+	return r.writeLayout(meta, oldMeta, data)
+}
 
-		for i := 0; i < r.DiskNum-2; i++ {
-			if node.CheckBlockExists(fileName, i) {
-				data, _ := node.ReadBlockFromDisk(fileName, i)
-				dataBlocks[i] = data
-				break
-			}
+// stripeUnchanged Check if the stored data of a stripe already matches the given data blocks
+func (r *RAID6) stripeUnchanged(meta *FileMeta, stripe int, dataBlocks [][]byte) bool {
+	oldBlocks, err := r.readStripeData(meta, stripe)
+	if err != nil {
+		return false
+	}
+	for i := range dataBlocks {
+		if !bytes.Equal(oldBlocks[i], dataBlocks[i]) {
+			return false
 		}
 	}
-
-	return dataBlocks, P, Q
+	return true
 }
 
 // NodeFailure Simulate single node's failure
@@ -284,134 +340,43 @@ func (r *RAID6) RecoverFile(nodeID int, fileName string) error {
 		return errors.New("file name is empty")
 	}
 
-	dataBlocks, P, Q := r.GetDataBlocks(fileName)
-	var blockIndex int
-	if len(P) == 0 {
-		blockIndex = -1
-	} else if len(Q) == 0 {
-		blockIndex = -2
-	} else {
-		for i, dataBlock := range dataBlocks {
-			if len(dataBlock) == 0 {
-				blockIndex = i
-			}
-		}
+	return r.recoverFile(fileName, nodeID)
+}
+
+// recoverFile Rebuild every stripe of a file that has blocks on the given nodes
+func (r *RAID6) recoverFile(fileName string, nodeIDs ...int) error {
+	meta, err := r.getFileMeta(fileName)
+	if err != nil {
+		return err
 	}
 
-	if blockIndex >= 0 {
-		dataBlock := r.Math.RecoverSingleBlockP(dataBlocks, P, blockIndex)
-		err := r.Nodes[nodeID].WriteBlockToDisk(InitBlock(blockIndex, fileName, &dataBlock, len(dataBlock)))
+	err = workers.runErr(meta.Stripes, func(stripe int) error {
+		err := r.RecoverStripe(meta, stripe, nodeIDs...)
 		if err != nil {
-			return err
+			return fmt.Errorf("recovery of stripe %d failed: %s", stripe, err.Error())
 		}
-	} else if blockIndex == -1 {
-		pBlock := r.Math.RecoverPParity(dataBlocks)
-		err := r.Nodes[nodeID].WriteBlockToDisk(InitBlock(-1, fileName, &pBlock, len(pBlock)))
-		if err != nil {
-			return err
-		}
-	} else if blockIndex == -2 {
-		qBlock := r.Math.RecoverQParity(dataBlocks)
-		err := r.Nodes[nodeID].WriteBlockToDisk(InitBlock(-2, fileName, &qBlock, len(qBlock)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Copy the metadata record from the surviving nodes as well
+	for _, nodeID := range nodeIDs {
+		err = r.Nodes[nodeID].WriteMetaToDisk(meta)
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-// RecoverSingleNode Single node recovery function
+// RecoverSingleNode Single node recovery function, resumes from the node's checkpoint if an earlier rebuild was interrupted
 func (r *RAID6) RecoverSingleNode(nodeID int) error {
-	// For the ith file
-	for _, fileName := range r.FileNames {
-		err := r.RecoverFile(nodeID, fileName)
-		if err != nil {
-			return err
-		}
-	}
-
-	r.Nodes[nodeID].status = true
-	return nil
+	return r.NewRebuildJob(0, nodeID).Run()
 }
 
 // RecoverDoubleNodes Double nodes recovery function. Assume that nodeID1 < nodeID2
 func (r *RAID6) RecoverDoubleNodes(nodeID1, nodeID2 int) error {
-	for _, fileName := range r.FileNames {
-		// Get the data blocks, P parity, and Q parity for the current file (index i)
-		dataBlocks, P, Q := r.GetDataBlocks(fileName)
-
-		// Block types of the failed blocks
-		var blockIndices []int
-		var err error
-		if len(Q) == 0 {
-			blockIndices = append(blockIndices, -2)
-		}
-		if len(P) == 0 {
-			blockIndices = append(blockIndices, -1)
-		}
-		for i, dataBlock := range dataBlocks {
-			if len(dataBlock) == 0 {
-				blockIndices = append(blockIndices, i)
-			}
-		}
-
-		blockIndex1 := blockIndices[1]
-		blockIndex2 := blockIndices[0]
-
-		// Recovery logic based on the block types of the two failed nodes
-		if blockIndex1 >= 0 && blockIndex2 >= 0 {
-			// Both blocks are normal data blocks, recover them using both P and Q parities
-			dataBlock1, dataBlock2 := r.Math.RecoverTwoDataBlocks(dataBlocks, P, Q, blockIndex1, blockIndex2)
-			err = r.Nodes[nodeID1].WriteBlockToDisk(InitBlock(blockIndex1, fileName, &dataBlock1, len(dataBlock1)))
-			if err != nil {
-				return fmt.Errorf("recovery of block %d failed: %s", blockIndex1, err.Error())
-			}
-			err = r.Nodes[nodeID2].WriteBlockToDisk(InitBlock(blockIndex2, fileName, &dataBlock2, len(dataBlock2)))
-			if err != nil {
-				return fmt.Errorf("recovery of block %d failed: %s", blockIndex2, err.Error())
-			}
-
-		} else if blockIndex1 >= 0 && blockIndex2 == -1 {
-			// Recover normal data block and recalculate P parity
-			dataBlock := r.Math.RecoverSingleBlockQ(dataBlocks, Q, blockIndex1) // Recover normal data block using Q
-			pBlock := r.Math.RecoverPParity(dataBlocks)                         // Recalculate P parity
-			err = r.Nodes[nodeID1].WriteBlockToDisk(InitBlock(blockIndex1, fileName, &dataBlock, len(dataBlock)))
-			if err != nil {
-				return fmt.Errorf("recovery of block %d failed: %s", blockIndex1, err.Error())
-			}
-			err = r.Nodes[nodeID2].WriteBlockToDisk(InitBlock(-1, fileName, &pBlock, len(pBlock)))
-			if err != nil {
-				return fmt.Errorf("recovery of block %d failed: %s", blockIndex2, err.Error())
-			}
-		} else if blockIndex1 >= 0 && blockIndex2 == -2 {
-			// Recover normal data block and recalculate Q parity
-			dataBlock := r.Math.RecoverSingleBlockP(dataBlocks, P, blockIndex1) // Recover normal data block using P
-			qBlock := r.Math.RecoverQParity(dataBlocks)                         // Recalculate Q parity
-			err = r.Nodes[nodeID1].WriteBlockToDisk(InitBlock(blockIndex1, fileName, &dataBlock, len(dataBlock)))
-			if err != nil {
-				return fmt.Errorf("recovery of block %d failed: %s", blockIndex1, err.Error())
-			}
-			err = r.Nodes[nodeID2].WriteBlockToDisk(InitBlock(-2, fileName, &qBlock, len(qBlock)))
-			if err != nil {
-				return fmt.Errorf("recovery of block %d failed: %s", blockIndex2, err.Error())
-			}
-
-		} else if blockIndex1 == -1 && blockIndex2 == -2 {
-			// Both P and Q parities are missing, recalculate both
-			P, Q = r.Math.RecoverPQParities(dataBlocks)
-			err = r.Nodes[nodeID1].WriteBlockToDisk(InitBlock(-1, fileName, &P, len(P)))
-			if err != nil {
-				return fmt.Errorf("recovery of block %d failed: %s", blockIndex1, err.Error())
-			}
-			err = r.Nodes[nodeID2].WriteBlockToDisk(InitBlock(-2, fileName, &Q, len(Q)))
-			if err != nil {
-				return fmt.Errorf("recovery of block %d failed: %s", blockIndex2, err.Error())
-			}
-		}
-	}
-
-	r.Nodes[nodeID1].status = true
-	r.Nodes[nodeID2].status = true
-	return nil
+	return r.NewRebuildJob(0, nodeID1, nodeID2).Run()
 }
@@ -0,0 +1,433 @@
+package raid6
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	rebuildCheckpointFileName = "rebuild.json"
+	checkpointInterval        = 64 // Stripes rebuilt between two checkpoints
+)
+
+// ErrRebuildStopped Returned by a rebuild job that was stopped before it completed
+var ErrRebuildStopped = errors.New("rebuild stopped")
+
+// RebuildCheckpoint Position of an interrupted rebuild, stored on the disks being rebuilt
+type RebuildCheckpoint struct {
+	NodeIDs []int    `json:"node_ids"`
+	Done    []string `json:"done"`    // Files completely rebuilt
+	File    string   `json:"file"`    // File being rebuilt
+	FileID  uint64   `json:"file_id"` // Version of File the stripe position refers to
+	Stripe  int      `json:"stripe"`  // Next stripe of File to rebuild
+}
+
+// RebuildProgress Snapshot of a rebuild job
+type RebuildProgress struct {
+	NodeIDs      []int
+	Percent      float64
+	BytesRebuilt int64
+	TotalBytes   int64
+	ETA          time.Duration
+	CurrentFile  string
+	Done         bool
+	Err          error
+}
+
+// RebuildJob Rebuilds all blocks of failed nodes stripe by stripe. The job is throttled to Rate bytes
+// per second of stripe I/O and checkpoints its position so an interrupted rebuild resumes where it stopped.
+type RebuildJob struct {
+	raid    *RAID6
+	NodeIDs []int
+	Rate    int64 // I/O limit in bytes per second, 0 for unlimited
+
+	mu           sync.Mutex
+	started      time.Time
+	resumedBytes int64 // Bytes already rebuilt by an earlier run
+	bytesRebuilt int64
+	totalBytes   int64
+	currentFile  string
+	finished     bool
+	err          error
+	stop         chan struct{}
+	stopOnce     sync.Once
+	done         chan struct{}
+}
+
+// NewRebuildJob Create a job rebuilding the given nodes, at most rate bytes per second
+func (r *RAID6) NewRebuildJob(rate int64, nodeIDs ...int) *RebuildJob {
+	return &RebuildJob{
+		raid:    r,
+		NodeIDs: nodeIDs,
+		Rate:    rate,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Rebuilds Return the rebuild jobs started by the cluster itself that are still running
+func (r *RAID6) Rebuilds() []*RebuildJob {
+	r.Lock()
+	defer r.Unlock()
+
+	return append([]*RebuildJob(nil), r.rebuilds...)
+}
+
+// startRebuild Rebuild the given nodes in the background at the cluster's rebuild rate
+func (r *RAID6) startRebuild(nodeIDs ...int) *RebuildJob {
+	job := r.NewRebuildJob(r.RebuildRate, nodeIDs...)
+	r.rebuilds = append(r.rebuilds, job)
+
+	go func() {
+		job.Run()
+
+		r.Lock()
+		defer r.Unlock()
+		for i, j := range r.rebuilds {
+			if j == job {
+				r.rebuilds = append(r.rebuilds[:i], r.rebuilds[i+1:]...)
+				break
+			}
+		}
+	}()
+	return job
+}
+
+// Stop Interrupt the job after the current stripe. Its position is checkpointed, so running a
+// new job for the same nodes resumes from there. The nodes keep receiving writes meanwhile.
+func (j *RebuildJob) Stop() error {
+	j.stopOnce.Do(func() { close(j.stop) })
+	return j.Wait()
+}
+
+// stopping Check if Stop was called
+func (j *RebuildJob) stopping() bool {
+	select {
+	case <-j.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait Block until the job has finished and return its error
+func (j *RebuildJob) Wait() error {
+	<-j.done
+	return j.Progress().Err
+}
+
+// Progress Return how far the job has come
+func (j *RebuildJob) Progress() RebuildProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	progress := RebuildProgress{
+		NodeIDs:      j.NodeIDs,
+		BytesRebuilt: j.bytesRebuilt,
+		TotalBytes:   j.totalBytes,
+		CurrentFile:  j.currentFile,
+		Done:         j.finished,
+		Err:          j.err,
+	}
+	if j.totalBytes > 0 {
+		progress.Percent = float64(j.bytesRebuilt) / float64(j.totalBytes) * 100
+	}
+
+	// Estimate from the speed of this run, the part resumed from a checkpoint took no time
+	doneThisRun := j.bytesRebuilt - j.resumedBytes
+	if doneThisRun > 0 && !j.finished {
+		elapsed := time.Since(j.started)
+		progress.ETA = time.Duration(float64(elapsed) / float64(doneThisRun) * float64(j.totalBytes-j.bytesRebuilt))
+	}
+	return progress
+}
+
+// Run Rebuild the nodes and mark them active once every file is done
+func (j *RebuildJob) Run() error {
+	err := j.run()
+
+	j.mu.Lock()
+	j.err = err
+	j.finished = true
+	j.currentFile = ""
+	j.mu.Unlock()
+	close(j.done)
+	return err
+}
+
+func (j *RebuildJob) run() error {
+	r := j.raid
+	limit := newThrottle(j.Rate)
+
+	cp, fileNames, err := j.prepare()
+	if err != nil {
+		return err
+	}
+	done := make(map[string]bool)
+	for _, fileName := range cp.Done {
+		done[fileName] = true
+	}
+
+	for _, fileName := range fileNames {
+		r.Lock()
+		meta, err := r.getFileMeta(fileName)
+		r.Unlock()
+		if err != nil {
+			continue // Deleted since the rebuild started
+		}
+		if done[fileName] {
+			continue
+		}
+
+		start := 0
+		if cp.File == fileName && cp.FileID == meta.FileID {
+			start = cp.Stripe
+		}
+		if !meta.onNodes(j.NodeIDs...) {
+			start = meta.Stripes // Striped across other nodes, only the metadata is copied
+		}
+		cp.File, cp.FileID = fileName, meta.FileID
+		j.mu.Lock()
+		j.currentFile = fileName
+		j.mu.Unlock()
+
+		for stripe := start; stripe < meta.Stripes; stripe++ {
+			if j.stopping() {
+				cp.Stripe = stripe
+				err = j.saveCheckpoint(cp)
+				if err != nil {
+					return err
+				}
+				return ErrRebuildStopped
+			}
+
+			r.Lock()
+			err = j.checkTargets()
+			if err == nil {
+				err = r.RecoverStripe(meta, stripe, j.NodeIDs...)
+			}
+			r.Unlock()
+			if err != nil {
+				return fmt.Errorf("recovery of stripe %d of file %s failed: %s", stripe, fileName, err.Error())
+			}
+
+			j.mu.Lock()
+			j.bytesRebuilt += int64(meta.BlockSize * len(j.NodeIDs))
+			j.mu.Unlock()
+			limit.wait(meta.BlockSize * len(meta.Nodes))
+
+			if (stripe+1)%checkpointInterval == 0 {
+				cp.Stripe = stripe + 1
+				err = j.saveCheckpoint(cp)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Copy the metadata record from the surviving nodes as well
+		err = j.copyFileMeta(fileName)
+		if err != nil {
+			return err
+		}
+
+		cp.Done = append(cp.Done, fileName)
+		cp.File, cp.FileID, cp.Stripe = "", 0, 0
+		err = j.saveCheckpoint(cp)
+		if err != nil {
+			return err
+		}
+	}
+
+	return j.finish()
+}
+
+// prepare Format the nodes, load their checkpoint and size the work
+func (j *RebuildJob) prepare() (*RebuildCheckpoint, []string, error) {
+	r := j.raid
+	r.Lock()
+	defer r.Unlock()
+
+	// Resume only if every node holds the checkpoint of this same rebuild
+	var cp *RebuildCheckpoint
+	for _, nodeID := range j.NodeIDs {
+		if nodeID < 0 || nodeID >= len(r.Nodes) {
+			return nil, nil, fmt.Errorf("node %d does not exist", nodeID)
+		}
+		saved, err := r.Nodes[nodeID].ReadRebuildCheckpoint()
+		if err != nil || !sameNodeIDs(saved.NodeIDs, j.NodeIDs) || (cp != nil && !sameCheckpoint(saved, cp)) {
+			cp = nil
+			break
+		}
+		cp = saved
+	}
+	if cp == nil {
+		cp = &RebuildCheckpoint{NodeIDs: j.NodeIDs}
+	}
+
+	for _, nodeID := range j.NodeIDs {
+		node := r.Nodes[nodeID]
+		node.status = false
+		node.rebuilding = true // Foreground writes keep the node up to date while it is rebuilt
+	}
+
+	// Stamp the nodes and clear their failed mark, from now on a restart resumes the rebuild
+	err := r.updateMembership()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, nodeID := range j.NodeIDs {
+		err = r.applyTombstones(r.Nodes[nodeID])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	fileNames := append([]string(nil), r.FileNames...)
+	done := make(map[string]bool)
+	for _, fileName := range cp.Done {
+		done[fileName] = true
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.started = time.Now()
+	for _, fileName := range fileNames {
+		meta, err := r.getFileMeta(fileName)
+		if err != nil || !meta.onNodes(j.NodeIDs...) {
+			continue
+		}
+		size := int64(meta.Stripes * meta.BlockSize * len(j.NodeIDs))
+		j.totalBytes += size
+		if done[fileName] {
+			j.bytesRebuilt += size
+		} else if cp.File == fileName && cp.FileID == meta.FileID {
+			j.bytesRebuilt += int64(cp.Stripe * meta.BlockSize * len(j.NodeIDs))
+		}
+	}
+	j.resumedBytes = j.bytesRebuilt
+	return cp, fileNames, nil
+}
+
+// copyFileMeta Write the current metadata record of a file to the nodes being rebuilt
+func (j *RebuildJob) copyFileMeta(fileName string) error {
+	r := j.raid
+	r.Lock()
+	defer r.Unlock()
+
+	meta, err := r.getFileMeta(fileName)
+	if err != nil {
+		return nil // Deleted while it was rebuilt
+	}
+	for _, nodeID := range j.NodeIDs {
+		err = r.Nodes[nodeID].WriteMetaToDisk(meta)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTargets Make sure no node failed again while it was rebuilt
+func (j *RebuildJob) checkTargets() error {
+	for _, nodeID := range j.NodeIDs {
+		if !j.raid.Nodes[nodeID].rebuilding {
+			return fmt.Errorf("node %d failed during rebuild", nodeID)
+		}
+	}
+	return nil
+}
+
+// finish Put the rebuilt nodes back in service and drop their checkpoints
+func (j *RebuildJob) finish() error {
+	r := j.raid
+	r.Lock()
+	defer r.Unlock()
+
+	err := j.checkTargets()
+	if err != nil {
+		return err
+	}
+	for _, nodeID := range j.NodeIDs {
+		node := r.Nodes[nodeID]
+		node.rebuilding = false
+		node.status = true
+		err = node.RemoveRebuildCheckpoint()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveCheckpoint Store the current position on every node being rebuilt
+func (j *RebuildJob) saveCheckpoint(cp *RebuildCheckpoint) error {
+	r := j.raid
+	r.Lock()
+	defer r.Unlock()
+
+	for _, nodeID := range j.NodeIDs {
+		err := r.Nodes[nodeID].WriteRebuildCheckpoint(cp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameNodeIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameCheckpoint(a, b *RebuildCheckpoint) bool {
+	if !sameNodeIDs(a.NodeIDs, b.NodeIDs) || len(a.Done) != len(b.Done) {
+		return false
+	}
+	for i := range a.Done {
+		if a.Done[i] != b.Done[i] {
+			return false
+		}
+	}
+	return a.File == b.File && a.FileID == b.FileID && a.Stripe == b.Stripe
+}
+
+// ReadRebuildCheckpoint reads the checkpoint of an interrupted rebuild from the node
+func (n *Node) ReadRebuildCheckpoint() (*RebuildCheckpoint, error) {
+	data, err := n.Store.Get(rebuildCheckpointFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &RebuildCheckpoint{}
+	err = json.Unmarshal(data, cp)
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// WriteRebuildCheckpoint writes the rebuild checkpoint to the node
+func (n *Node) WriteRebuildCheckpoint(cp *RebuildCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	return n.Store.Put(rebuildCheckpointFileName, data)
+}
+
+// RemoveRebuildCheckpoint removes the rebuild checkpoint from the node
+func (n *Node) RemoveRebuildCheckpoint() error {
+	return n.Store.Delete(rebuildCheckpointFileName)
+}
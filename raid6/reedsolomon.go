@@ -0,0 +1,212 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// MaxParityBlocks Largest number of parity blocks per stripe supported by ReedSolomon
+const MaxParityBlocks = 8
+
+// ReedSolomon Systematic Reed-Solomon code over GF(2^8) with any number of data blocks and up to
+// MaxParityBlocks parities. Parity i weights data block j with 1/(x_i + y_j), a Cauchy matrix, so
+// every square submatrix is invertible and any parityBlocks lost blocks of a stripe can be rebuilt.
+type ReedSolomon struct {
+	math         *RAIDMath
+	parityBlocks int
+
+	mu       sync.Mutex
+	matrices map[int][][]byte // Number of data blocks to the parity rows of the coding matrix
+}
+
+// NewReedSolomon Create a code with the given number of parity blocks per stripe. Two parities are
+// served by the P and Q code, whose kernels are faster and which patches parities from data deltas.
+func NewReedSolomon(parityBlocks int) (ErasureCode, error) {
+	if parityBlocks == 2 {
+		return newPQCode(NewRAIDMath(2)), nil
+	}
+	return newReedSolomon(parityBlocks)
+}
+
+// newReedSolomon Create the Cauchy code itself, for any supported number of parity blocks
+func newReedSolomon(parityBlocks int) (*ReedSolomon, error) {
+	if parityBlocks < 1 || parityBlocks > MaxParityBlocks {
+		return nil, fmt.Errorf("number of parity blocks must be between 1 and %d", MaxParityBlocks)
+	}
+
+	return &ReedSolomon{
+		math:         NewRAIDMath(2),
+		parityBlocks: parityBlocks,
+		matrices:     make(map[int][][]byte),
+	}, nil
+}
+
+func (rs *ReedSolomon) Name() string {
+	return ReedSolomonCodeName
+}
+
+func (rs *ReedSolomon) ParityBlocks() int {
+	return rs.parityBlocks
+}
+
+// parityMatrix Return the parity rows of the coding matrix for stripes of k data blocks
+func (rs *ReedSolomon) parityMatrix(k int) [][]byte {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if matrix, ok := rs.matrices[k]; ok {
+		return matrix
+	}
+
+	// x_i = i and y_j = m + j are distinct elements, so x_i + y_j is never zero. They stay within
+	// GF(2^8) as long as m + k <= MaxStripeBlocks, which the cluster checks before writing.
+	matrix := make([][]byte, rs.parityBlocks)
+	for i := range matrix {
+		matrix[i] = make([]byte, k)
+		for j := 0; j < k; j++ {
+			matrix[i][j] = byte(rs.math.GfInverse(i ^ (rs.parityBlocks + j)))
+		}
+	}
+	rs.matrices[k] = matrix
+	return matrix
+}
+
+// Encode Compute every parity block of a stripe
+func (rs *ReedSolomon) Encode(dataBlocks [][]byte, blockSize int) [][]byte {
+	matrix := rs.parityMatrix(len(dataBlocks))
+	parity := make([][]byte, rs.parityBlocks)
+	for i := range parity {
+		parity[i] = rs.combine(matrix[i], dataBlocks, blockSize)
+	}
+	return parity
+}
+
+// combine Sum the blocks weighted with the given coefficients
+func (rs *ReedSolomon) combine(coefs []byte, blocks [][]byte, blockSize int) []byte {
+	out := make([]byte, blockSize)
+	workers.ranges(blockSize, func(lo, hi int) {
+		for j, coef := range coefs {
+			rs.math.MulSliceXor(coef, blocks[j][lo:hi], out[lo:hi])
+		}
+	})
+	return out
+}
+
+// UpdateParity Add the change of data block index, weighted by its column of the coding matrix, to every parity
+func (rs *ReedSolomon) UpdateParity(parity [][]byte, numDataBlocks, index int, delta []byte) {
+	matrix := rs.parityMatrix(numDataBlocks)
+	for i := range parity {
+		rs.math.MulSliceXor(matrix[i][index], delta, parity[i])
+	}
+}
+
+// ReconstructData Rebuild missing data blocks from any k surviving blocks of the stripe
+func (rs *ReedSolomon) ReconstructData(dataBlocks, parity [][]byte) error {
+	k := len(dataBlocks)
+	var missing []int
+	for i, dataBlock := range dataBlocks {
+		if len(dataBlock) == 0 {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	// Pick k surviving blocks and the rows of the coding matrix that produced them
+	matrix := rs.parityMatrix(k)
+	rows := make([][]byte, 0, k)
+	blocks := make([][]byte, 0, k)
+	blockSize := 0
+	for i, dataBlock := range dataBlocks {
+		if len(dataBlock) != 0 {
+			row := make([]byte, k)
+			row[i] = 1
+			rows = append(rows, row)
+			blocks = append(blocks, dataBlock)
+			blockSize = len(dataBlock)
+		}
+	}
+	for i := 0; i < len(parity) && len(rows) < k; i++ {
+		if len(parity[i]) != 0 {
+			rows = append(rows, matrix[i])
+			blocks = append(blocks, parity[i])
+			blockSize = len(parity[i])
+		}
+	}
+	if len(rows) < k {
+		return errors.New("too many node failures to reconstruct file")
+	}
+
+	// The surviving blocks are rows * data, so data = rows^-1 * surviving blocks
+	inverse, err := rs.math.invertMatrix(rows)
+	if err != nil {
+		return err
+	}
+	for _, i := range missing {
+		dataBlocks[i] = rs.combine(inverse[i], blocks, blockSize)
+	}
+	return nil
+}
+
+// Reconstruct Rebuild missing data blocks, then recompute missing parities from the complete data
+func (rs *ReedSolomon) Reconstruct(dataBlocks, parity [][]byte) error {
+	err := rs.ReconstructData(dataBlocks, parity)
+	if err != nil {
+		return err
+	}
+
+	matrix := rs.parityMatrix(len(dataBlocks))
+	for i := range parity {
+		if len(parity[i]) == 0 {
+			parity[i] = rs.combine(matrix[i], dataBlocks, len(dataBlocks[0]))
+		}
+	}
+	return nil
+}
+
+// invertMatrix Invert a square matrix over GF(2^8) by Gauss-Jordan elimination
+func (rm *RAIDMath) invertMatrix(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+
+	// Work on [matrix | identity] until the left half is the identity
+	work := make([][]byte, n)
+	for i := range work {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], matrix[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		// Scale the pivot row to 1, then clear the column in every other row
+		scale := rm.GfInverse(int(work[col][col]))
+		for j := range work[col] {
+			work[col][j] = byte(rm.GfMul(int(work[col][j]), scale))
+		}
+		for row := 0; row < n; row++ {
+			factor := int(work[row][col])
+			if row == col || factor == 0 {
+				continue
+			}
+			for j := range work[row] {
+				work[row][j] ^= byte(rm.GfMul(factor, int(work[col][j])))
+			}
+		}
+	}
+
+	inverse := make([][]byte, n)
+	for i := range inverse {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, nil
+}
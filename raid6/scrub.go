@@ -0,0 +1,259 @@
+package raid6
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// ScrubResult Statistics of one pass of the scrubber over every file
+type ScrubResult struct {
+	Started        time.Time
+	Duration       time.Duration
+	FilesChecked   int
+	StripesChecked int
+	StripesSkipped int // Stripes with blocks on failed nodes, left to node recovery
+	BytesChecked   int64
+	ErrorsFound    int // Blocks found unreadable, failing their checksum or disagreeing with the parities
+	ErrorsFixed    int // Blocks rewritten with their repaired content
+	Aborted        bool
+}
+
+// Scrubber Walks every stripe of the RAID, verifies the stored parities and repairs single bad blocks
+type Scrubber struct {
+	raid    *RAID6
+	Rate    int64 // I/O limit in bytes per second, 0 for unlimited
+	results []ScrubResult
+	stop    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+// NewScrubber Create a scrubber reading at most rate bytes per second
+func (r *RAID6) NewScrubber(rate int64) *Scrubber {
+	return &Scrubber{
+		raid: r,
+		Rate: rate,
+	}
+}
+
+// Start Run a scrub pass every interval in the background until Stop is called
+func (s *Scrubber) Start(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return // Already running
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		for {
+			s.RunOnce()
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}(s.stop, s.done)
+}
+
+// Stop Stop the background scrubber, the pass in progress ends after its current stripe
+func (s *Scrubber) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+
+	s.mu.Lock()
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+}
+
+// Results Return the results of all finished passes, oldest first
+func (s *Scrubber) Results() []ScrubResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ScrubResult(nil), s.results...)
+}
+
+// stopping Check if Stop was called
+func (s *Scrubber) stopping() bool {
+	s.mu.Lock()
+	stop := s.stop
+	s.mu.Unlock()
+
+	if stop == nil {
+		return false
+	}
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunOnce Scrub every stripe of every file once and record the result
+func (s *Scrubber) RunOnce() ScrubResult {
+	result := ScrubResult{Started: time.Now()}
+	limit := newThrottle(s.Rate)
+
+	s.raid.Lock()
+	fileNames := append([]string(nil), s.raid.FileNames...)
+	s.raid.Unlock()
+
+	for _, fileName := range fileNames {
+		s.raid.Lock()
+		meta, err := s.raid.getFileMeta(fileName)
+		s.raid.Unlock()
+		if err != nil {
+			continue // Deleted since the pass started
+		}
+
+		for stripe := 0; stripe < meta.Stripes; stripe++ {
+			if s.stopping() {
+				result.Aborted = true
+				break
+			}
+			s.scrubStripe(meta, stripe, &result)
+			limit.wait(meta.BlockSize * len(meta.Nodes))
+		}
+		if result.Aborted {
+			break
+		}
+		result.FilesChecked++
+	}
+
+	result.Duration = time.Since(result.Started)
+	s.mu.Lock()
+	s.results = append(s.results, result)
+	s.mu.Unlock()
+	return result
+}
+
+// scrubStripe Check one stripe against its parities and repair what can be located
+func (s *Scrubber) scrubStripe(meta *FileMeta, stripe int, result *ScrubResult) {
+	r := s.raid
+	r.Lock()
+	defer r.Unlock()
+
+	if r.metaStale(meta) {
+		result.StripesSkipped++ // Moved to another layout or renumbered since the pass started
+		return
+	}
+	result.StripesChecked++
+	result.BytesChecked += int64(meta.BlockSize * len(meta.Nodes))
+	dataBlocks, parity := r.GetStripeBlocks(meta, stripe)
+
+	// Blocks missing or failing their checksum on active nodes are rebuilt from the rest of the stripe
+	var lost []int
+	degraded := false
+	for i, nodeID := range r.stripeNodes(meta, stripe) {
+		if len(stripeBlock(dataBlocks, parity, blockIDAt(i, meta.DataDisks))) != 0 {
+			continue
+		}
+		if r.node(nodeID).status {
+			lost = append(lost, nodeID)
+		} else {
+			degraded = true
+		}
+	}
+	if len(lost) > 0 {
+		result.ErrorsFound += len(lost)
+		if r.RecoverStripe(meta, stripe, lost...) == nil {
+			result.ErrorsFixed += len(lost)
+		}
+		return
+	}
+	if degraded {
+		result.StripesSkipped++
+		return
+	}
+
+	// Re-derive the parities and compare them with the stored ones
+	if consistent(r.Code, dataBlocks, parity, meta.BlockSize) {
+		return
+	}
+
+	if r.Code.Name() != PQCodeName {
+		s.repairByErasure(meta, stripe, dataBlocks, parity, result)
+		return
+	}
+
+	// Locate the bad block from the syndromes, only a single culprit can be repaired with confidence
+	dataBlocks, P, Q, report := r.Math.RepairCorruptedDataBlocks(dataBlocks, parity[0], parity[1])
+	parity = [][]byte{P, Q}
+	if report.Conflicting() || len(report.Uncorrectable) > 0 {
+		result.ErrorsFound += len(report.Blocks)
+		if len(report.Blocks) == 0 {
+			result.ErrorsFound++
+		}
+		return
+	}
+
+	nodes := r.stripeNodes(meta, stripe)
+	for blockID := range report.Blocks {
+		result.ErrorsFound++
+		data := stripeBlock(dataBlocks, parity, blockID)
+		err := r.node(nodes[blockPos(blockID, meta.DataDisks)]).WriteBlockToDisk(newBlock(meta, stripe, blockID, &data))
+		if err == nil {
+			result.ErrorsFixed++
+		}
+	}
+}
+
+// repairByErasure Locate a bad block by treating each block of the stripe in turn as lost. Only the culprit
+// leaves a stripe that agrees with all of its parities once rebuilt, which takes at least two parities.
+func (s *Scrubber) repairByErasure(meta *FileMeta, stripe int, dataBlocks, parity [][]byte, result *ScrubResult) {
+	r := s.raid
+	result.ErrorsFound++
+
+	culprit, candidates := 0, 0
+	var repaired []byte
+	for i := 0; i < len(dataBlocks)+len(parity); i++ {
+		blockID := blockIDAt(i, meta.DataDisks)
+		trialData := append([][]byte(nil), dataBlocks...)
+		trialParity := append([][]byte(nil), parity...)
+		if blockID < 0 {
+			trialParity[-blockID-1] = nil
+		} else {
+			trialData[blockID] = nil
+		}
+
+		if r.Code.Reconstruct(trialData, trialParity) != nil || !consistent(r.Code, trialData, trialParity, meta.BlockSize) {
+			continue
+		}
+		culprit = blockID
+		repaired = stripeBlock(trialData, trialParity, blockID)
+		candidates++
+	}
+	if candidates != 1 {
+		return
+	}
+
+	nodeID := r.stripeNodes(meta, stripe)[blockPos(culprit, meta.DataDisks)]
+	err := r.node(nodeID).WriteBlockToDisk(newBlock(meta, stripe, culprit, &repaired))
+	if err == nil {
+		result.ErrorsFixed++
+	}
+}
+
+// consistent Check if the stored parities match the data blocks of a stripe
+func consistent(code ErasureCode, dataBlocks, parity [][]byte, blockSize int) bool {
+	for i, p := range code.Encode(dataBlocks, blockSize) {
+		if !bytes.Equal(p, parity[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AddSpare Register a hot spare that takes over the next failed node
+func (r *RAID6) AddSpare(node *Node) error {
+	r.Lock()
+	defer r.Unlock()
+
+	node.NodeID = -1
+	node.status = true
+	r.Spares = append(r.Spares, node)
+
+	err := r.formatNode(node)
+	if err != nil {
+		r.Spares = r.Spares[:len(r.Spares)-1]
+		return err
+	}
+	return r.updateMembership()
+}
+
+// MarkFailed Take a node out of service. If a hot spare is registered it is promoted in place of
+// the node and a background rebuild job, listed by Rebuilds, restores all of the node's blocks onto it.
+// Otherwise the node is recorded as failed in the superblocks, so a restart does not serve its blocks.
+func (r *RAID6) MarkFailed(nodeID int) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if nodeID < 0 || nodeID >= len(r.Nodes) {
+		return fmt.Errorf("node %d does not exist", nodeID)
+	}
+	r.Nodes[nodeID].status = false
+	r.Nodes[nodeID].rebuilding = false
+
+	if len(r.Spares) == 0 {
+		return r.updateMembership() // Degraded until the node is recovered by hand
+	}
+	return r.promoteSpare(nodeID)
+}
+
+// promoteDropped Promote hot spares for the nodes a write took out of service, the way MarkFailed does.
+// Called with the cluster lock held once no block writes are running.
+func (r *RAID6) promoteDropped() {
+	dropped := r.dropped
+	r.dropped = nil
+	for _, node := range dropped {
+		if len(r.Spares) == 0 {
+			return // Degraded until the node is recovered by hand
+		}
+		if node.NodeID < 0 || node.NodeID >= len(r.Nodes) || r.Nodes[node.NodeID] != node || node.writable() {
+			continue // Left the cluster or recovered since
+		}
+		r.promoteSpare(node.NodeID) // Best effort, the write itself succeeded
+	}
+}
+
+// promoteSpare Swap the first hot spare in for a failed node and start rebuilding the node's blocks onto it
+func (r *RAID6) promoteSpare(nodeID int) error {
+	if len(r.Spares) == 0 {
+		return errors.New("no hot spare available")
+	}
+
+	spare := r.Spares[0]
+	r.Spares = r.Spares[1:]
+	spare.NodeID = nodeID
+	spare.status = false // Not served from until the rebuild completes
+	r.Nodes[nodeID] = spare
+
+	// The failed disk drops out of the membership, the spare takes its node ID
+	err := r.updateMembership()
+	if err != nil {
+		return err
+	}
+	err = r.formatNode(spare)
+	if err != nil {
+		return err
+	}
+
+	r.startRebuild(nodeID)
+	return nil
+}
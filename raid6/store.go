@@ -0,0 +1,169 @@
+package raid6
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BlockStore Storage backend of a node. Blocks, metadata records and the node's bookkeeping files are
+// all objects addressed by a flat key without '/'. Get and Stat of a missing key return an error
+// matching fs.ErrNotExist, Delete of a missing key succeeds.
+type BlockStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Has(key string) (bool, error)
+	Delete(key string) error
+	List(prefix string) ([]string, error) // Keys starting with prefix in lexical order
+	Stat(key string) (BlockInfo, error)
+}
+
+// storeFactory Create the store of a new node
+type storeFactory func(diskPath string) BlockStore
+
+// BlockInfo Describes a stored object
+type BlockInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// tempPrefix Prefix of the temp files Put writes before renaming them over their key, never a valid key
+const tempPrefix = ".tmp-"
+
+// DirStore Keeps every object as a file of the same name in a local directory. Objects are replaced
+// atomically, after a crash every key holds either its complete old or complete new content.
+type DirStore struct {
+	Dir string
+}
+
+// NewDirStore Create a store backed by the given directory, the directory is created on the first Put
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{Dir: dir}
+}
+
+func (s *DirStore) path(key string) string {
+	return filepath.Join(s.Dir, key)
+}
+
+func (s *DirStore) Put(key string, data []byte) error {
+	file, err := os.CreateTemp(s.Dir, tempPrefix+key+"-*")
+	if errors.Is(err, fs.ErrNotExist) {
+		// The directory is gone, e.g. a replaced disk, so recreate it
+		err = os.MkdirAll(s.Dir, os.ModePerm)
+		if err != nil {
+			return err
+		}
+		file, err = os.CreateTemp(s.Dir, tempPrefix+key+"-*")
+	}
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+
+	// The new content must be on disk before the rename makes it visible under the key
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, s.path(key))
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// Persist the directory entry so the rename itself survives a crash
+	return s.syncDir()
+}
+
+// syncDir Flush the directory so created, renamed and removed entries are durable
+func (s *DirStore) syncDir() error {
+	dir, err := os.Open(s.Dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// RemoveTempFiles Remove the temp files of writes interrupted by a crash
+func (s *DirStore) RemoveTempFiles() error {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue
+		}
+		err = os.Remove(s.path(entry.Name()))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DirStore) Get(key string) ([]byte, error) {
+	return os.ReadFile(s.path(key))
+}
+
+func (s *DirStore) Has(key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *DirStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *DirStore) List(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue // Writes in flight are not objects yet
+		}
+		if strings.HasPrefix(entry.Name(), prefix) {
+			keys = append(keys, entry.Name())
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *DirStore) Stat(key string) (BlockInfo, error) {
+	info, err := os.Stat(s.path(key))
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	return BlockInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
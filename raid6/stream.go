@@ -0,0 +1,364 @@
+package raid6
+
+import (
+	"errors"
+	"io"
+)
+
+// fileWriter Streams file data into the RAID one stripe at a time
+type fileWriter struct {
+	raid     *RAID6
+	fileName string
+	meta     *FileMeta
+	replaces *FileMeta // File of the same name replaced on Close, nil for a new file
+	buf      []byte    // Data of the stripe being filled
+	stripe   int       // Index of the stripe being filled
+	size     int       // Bytes written so far
+	closed   bool
+}
+
+// fileReader Decodes file data lazily, keeping the last read stripe in memory
+type fileReader struct {
+	raid   *RAID6
+	meta   *FileMeta
+	offset int64
+	stripe int    // Index of the cached stripe, -1 if none
+	data   []byte // Data of the cached stripe
+	closed bool
+}
+
+// Create Open a new file for writing, stripes are encoded and written as soon as they are full.
+// The name is reserved until the writer is closed. With Overwrite on, an existing file of the same
+// name stays readable until Close replaces it.
+func (r *RAID6) Create(fileName string) (io.WriteCloser, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	oldMeta, err := r.claimFileName(fileName)
+	if err != nil {
+		return nil, err
+	}
+	r.creating[fileName] = true
+
+	return &fileWriter{
+		raid:     r,
+		fileName: fileName,
+		replaces: oldMeta,
+	}, nil
+}
+
+// stripeCapacity Number of data bytes in a full stripe of the cluster
+func (r *RAID6) stripeCapacity() int {
+	chunkSize := r.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return chunkSize * (len(r.layoutNodes()) - r.Code.ParityBlocks())
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed file")
+	}
+
+	n := len(p)
+	stripeSize := w.raid.stripeCapacity()
+	if w.meta != nil {
+		stripeSize = w.meta.BlockSize * w.meta.DataDisks // The cluster may have grown since the first stripe
+	}
+	for len(p) > 0 {
+		free := stripeSize - len(w.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+		w.buf = append(w.buf, p[:free]...)
+		p = p[free:]
+
+		if len(w.buf) == stripeSize {
+			err := w.flushStripe()
+			if err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flushStripe Encode the buffered stripe and write it to the nodes
+func (w *fileWriter) flushStripe() error {
+	w.raid.Lock()
+	defer w.raid.Unlock()
+	defer w.raid.promoteDropped()
+
+	if w.meta == nil {
+		// The file spans more than one stripe, plan it with full size blocks
+		meta, err := w.raid.planReplacement(w.fileName, len(w.buf), w.replaces)
+		if err != nil {
+			return err
+		}
+		w.meta = meta
+	}
+
+	err := w.raid.writeStripe(w.meta, w.stripe, splitStripe(w.meta, w.buf, 0))
+	if err != nil {
+		return err
+	}
+
+	w.size += len(w.buf)
+	w.stripe++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close Write the last partial stripe and the file metadata, then add the file to the catalog or
+// replace the previous file of the same name
+func (w *fileWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.release()
+
+	if w.meta == nil {
+		if len(w.buf) == 0 {
+			return errors.New("file data is empty")
+		}
+		// The whole file fits into one stripe, plan it with its exact size
+		w.raid.Lock()
+		meta, err := w.raid.planReplacement(w.fileName, len(w.buf), w.replaces)
+		w.raid.Unlock()
+		if err != nil {
+			return err
+		}
+		w.meta = meta
+	}
+	if len(w.buf) > 0 {
+		err := w.flushStripe()
+		if err != nil {
+			w.raid.Lock()
+			w.raid.deleteLayout(w.fileName, w.meta.Layout) // Best effort, the previous file is still intact
+			w.raid.Unlock()
+			return err
+		}
+	}
+
+	w.raid.Lock()
+	defer w.raid.Unlock()
+	defer w.raid.promoteDropped()
+
+	w.meta.Size = w.size
+	w.meta.Stripes = w.stripe
+	return w.raid.commitFile(w.meta, w.replaces)
+}
+
+// release Free the name reserved by Create
+func (w *fileWriter) release() {
+	w.raid.Lock()
+	defer w.raid.Unlock()
+
+	delete(w.raid.creating, w.fileName)
+}
+
+// Open Open a file for reading, stripes are read and reconstructed only when the data is needed
+func (r *RAID6) Open(fileName string) (io.ReadSeekCloser, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.fileExists(fileName) {
+		return nil, errors.New("file does not exist")
+	}
+	meta, err := r.getFileMeta(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileReader{
+		raid:   r,
+		meta:   meta,
+		stripe: -1,
+	}, nil
+}
+
+func (f *fileReader) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, errors.New("read from closed file")
+	}
+	if f.offset >= int64(f.meta.Size) {
+		return 0, io.EOF
+	}
+
+	stripeSize := int64(f.meta.BlockSize * f.meta.DataDisks)
+	if int(f.offset/stripeSize) != f.stripe {
+		err := f.loadStripe(f.offset)
+		if err != nil {
+			return 0, err
+		}
+		stripeSize = int64(f.meta.BlockSize * f.meta.DataDisks) // The layout may have been reloaded
+	}
+
+	start := f.offset - int64(f.stripe)*stripeSize
+	end := int64(len(f.data))
+	if remaining := int64(f.meta.Size) - f.offset; end-start > remaining {
+		end = start + remaining
+	}
+	n := copy(p, f.data[start:end])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// loadStripe Read the stripe holding byte off, reconstructing missing data blocks in memory. If the
+// file was restriped, replaced or renumbered since it was opened, its current metadata is loaded
+// first and the stripe is read from the new layout.
+func (f *fileReader) loadStripe(off int64) error {
+	f.raid.Lock()
+	defer f.raid.Unlock()
+
+	err := f.refresh()
+	if err != nil {
+		return err
+	}
+	if off >= int64(f.meta.Size) {
+		return io.EOF
+	}
+	stripe := int(off / int64(f.meta.BlockSize*f.meta.DataDisks))
+	dataBlocks, err := f.raid.readStripeData(f.meta, stripe)
+	if err != nil {
+		return err
+	}
+
+	f.data = f.data[:0]
+	for _, dataBlock := range dataBlocks {
+		f.data = append(f.data, dataBlock...)
+	}
+	f.stripe = stripe
+	return nil
+}
+
+// refresh Replace the metadata of the open file with the current one if it went stale, and drop the
+// cached stripe. Must hold the RAID lock.
+func (f *fileReader) refresh() error {
+	if !f.raid.metaStale(f.meta) {
+		return nil
+	}
+	meta, err := f.raid.getFileMeta(f.meta.FileName)
+	if err != nil {
+		return err
+	}
+	f.meta = meta
+	f.stripe = -1
+	f.data = f.data[:0]
+	return nil
+}
+
+// ReadAt Read from an offset of the open file without moving the read position
+func (f *fileReader) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, errors.New("read from closed file")
+	}
+
+	f.raid.Lock()
+	defer f.raid.Unlock()
+	err := f.refresh()
+	if err != nil {
+		return 0, err
+	}
+	return f.raid.readAt(f.meta, p, off)
+}
+
+func (f *fileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(f.meta.Size)
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *fileReader) Close() error {
+	f.closed = true
+	f.data = nil
+	return nil
+}
+
+// ReadAt Read len(p) bytes of a file starting at off, following the io.ReaderAt contract.
+// Only the data blocks covering the requested span are read from the nodes.
+func (r *RAID6) ReadAt(fileName string, p []byte, off int64) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.fileExists(fileName) {
+		return 0, errors.New("file does not exist")
+	}
+	meta, err := r.getFileMeta(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.readAt(meta, p, off)
+}
+
+// readAt Map a byte span of a file to stripes and data blocks and copy it from those blocks
+func (r *RAID6) readAt(meta *FileMeta, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(meta.Size) {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > int64(meta.Size) {
+		end = int64(meta.Size)
+	}
+
+	blockSize := int64(meta.BlockSize)
+	stripeSize := blockSize * int64(meta.DataDisks)
+	n := 0
+	for pos := off; pos < end; {
+		stripe := int(pos / stripeSize)
+		blockID := int(pos % stripeSize / blockSize)
+		start := pos % blockSize
+		length := blockSize - start
+		if length > end-pos {
+			length = end - pos
+		}
+
+		data, err := r.readDataBlock(meta, stripe, blockID)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[start:start+length])
+		pos += length
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readDataBlock Read a single data block, reconstructing it from the rest of its stripe only if it is missing
+func (r *RAID6) readDataBlock(meta *FileMeta, stripe, blockID int) ([]byte, error) {
+	node := r.node(r.stripeNodes(meta, stripe)[blockID])
+	if node.status {
+		data, err := node.ReadBlockFromDisk(meta.FileName, meta.Layout, stripe, blockID, meta.FileID)
+		if err == nil && len(data) == meta.BlockSize {
+			return data, nil
+		}
+	}
+
+	dataBlocks, err := r.readStripeData(meta, stripe)
+	if err != nil {
+		return nil, err
+	}
+	return dataBlocks[blockID], nil
+}
@@ -0,0 +1,337 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// DefaultChunkSize Size of a single block in a full stripe
+const DefaultChunkSize = 64 * 1024
+
+// newFileMeta Plan the stripe layout of a new file of the given size across all nodes of the cluster
+func (r *RAID6) newFileMeta(fileName string, size int) *FileMeta {
+	return r.planFileMeta(fileName, size, r.layoutNodes())
+}
+
+// planFileMeta Plan the stripe layout of a file of the given size across the given nodes
+func (r *RAID6) planFileMeta(fileName string, size int, nodes []int) *FileMeta {
+	numDataBlocks := len(nodes) - r.Code.ParityBlocks() // The remaining disks hold the parities
+	chunkSize := r.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	// Files smaller than one full stripe use a single stripe with smaller blocks to limit padding
+	blockSize := chunkSize
+	if size < chunkSize*numDataBlocks {
+		blockSize = (size + numDataBlocks - 1) / numDataBlocks
+	}
+	stripeSize := blockSize * numDataBlocks
+	stripes := (size + stripeSize - 1) / stripeSize
+
+	meta := InitFileMeta(fileName, size, blockSize, numDataBlocks, stripes)
+
+	// Randomly select where the parity of the first stripe goes, the following stripes rotate from there
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano())) // Seed the random number generator
+	meta.Nodes = append([]int(nil), nodes...)
+	meta.Rotation = rnd.Intn(len(nodes))
+	meta.FileID = rnd.Uint64() // Tags the blocks so stale ones from a previous file of the same name are rejected
+	meta.Epoch = r.Epoch
+	return meta
+}
+
+// layoutNodes Return the IDs of the nodes newly written files are striped across, all but retiring ones
+func (r *RAID6) layoutNodes() []int {
+	nodes := make([]int, 0, len(r.Nodes))
+	for _, node := range r.Nodes {
+		if !node.retiring {
+			nodes = append(nodes, node.NodeID)
+		}
+	}
+	return nodes
+}
+
+// node Return the node with the given ID. An ID past the end of the cluster, as left in metadata read
+// before a decommission renumbered the nodes, gives a detached node that is neither read nor written.
+func (r *RAID6) node(nodeID int) *Node {
+	if nodeID < 0 || nodeID >= len(r.Nodes) {
+		return &Node{NodeID: nodeID}
+	}
+	return r.Nodes[nodeID]
+}
+
+// stripeNodes Return the node index holding each block of a stripe: data blocks first, then the parities.
+// The parities of a stripe sit on consecutive nodes, starting one node further for every stripe.
+func (r *RAID6) stripeNodes(meta *FileMeta, stripe int) []int {
+	width := len(meta.Nodes)
+	parityBlocks := width - meta.DataDisks
+	pIndex := (meta.Rotation + stripe) % width
+
+	isParity := make([]bool, width)
+	parityNodes := make([]int, parityBlocks)
+	for i := range parityNodes {
+		isParity[(pIndex+i)%width] = true
+		parityNodes[i] = meta.Nodes[(pIndex+i)%width]
+	}
+
+	nodes := make([]int, 0, width)
+	for i, nodeID := range meta.Nodes {
+		if !isParity[i] {
+			nodes = append(nodes, nodeID)
+		}
+	}
+	return append(nodes, parityNodes...)
+}
+
+// blockIDOnNode Return the ID of the block a node holds for a stripe, false if the file has no block on the node
+func (r *RAID6) blockIDOnNode(meta *FileMeta, stripe, nodeID int) (int, bool) {
+	for i, n := range r.stripeNodes(meta, stripe) {
+		if n == nodeID {
+			return blockIDAt(i, meta.DataDisks), true
+		}
+	}
+	return 0, false
+}
+
+// blockIDAt Convert a position in stripeNodes to a block ID: -1 for P parity, -2 for Q parity and so on
+func blockIDAt(i, numDataBlocks int) int {
+	if i < numDataBlocks {
+		return i
+	}
+	return numDataBlocks - 1 - i
+}
+
+// blockPos Convert a block ID to its position in stripeNodes
+func blockPos(blockID, numDataBlocks int) int {
+	if blockID >= 0 {
+		return blockID
+	}
+	return numDataBlocks - 1 - blockID
+}
+
+// newBlock Build a block of the file tagged with its file ID
+func newBlock(meta *FileMeta, stripe, blockID int, data *[]byte) *Block {
+	block := InitBlock(blockID, stripe, meta.FileName, data, meta.BlockSize)
+	block.FileID = meta.FileID
+	block.Layout = meta.Layout
+	return block
+}
+
+// splitStripe Copy the data of one stripe into zero padded data blocks
+func splitStripe(meta *FileMeta, data []byte, stripe int) [][]byte {
+	offset := min(stripe*meta.BlockSize*meta.DataDisks, len(data))
+	return splitChunk(meta, data[offset:])
+}
+
+// splitChunk Copy the data starting at a stripe boundary into the zero padded data blocks of that stripe
+func splitChunk(meta *FileMeta, chunk []byte) [][]byte {
+	dataBlocks := make([][]byte, meta.DataDisks)
+	for i := 0; i < meta.DataDisks; i++ {
+		dataBlocks[i] = make([]byte, meta.BlockSize)
+		start := i * meta.BlockSize
+		end := start + meta.BlockSize
+		if end > len(chunk) {
+			end = len(chunk)
+		}
+		if start < end {
+			copy(dataBlocks[i], chunk[start:end])
+		}
+	}
+	return dataBlocks
+}
+
+// writeStripe Calculate the parity of a stripe and write all its blocks to the active nodes,
+// every node is written concurrently
+func (r *RAID6) writeStripe(meta *FileMeta, stripe int, dataBlocks [][]byte) error {
+	parity := r.Code.Encode(dataBlocks, meta.BlockSize)
+
+	nodes := r.stripeNodes(meta, stripe)
+	err := workers.runErr(len(nodes), func(i int) error {
+		node := r.node(nodes[i])
+		if !node.writable() {
+			return nil // The block is rebuilt when the node is recovered
+		}
+
+		blockID := blockIDAt(i, meta.DataDisks)
+		data := stripeBlock(dataBlocks, parity, blockID)
+		return r.putBlock(node, newBlock(meta, stripe, blockID, &data))
+	})
+	if err != nil {
+		return err
+	}
+	return r.checkStripeNodes(meta, stripe)
+}
+
+// writeBlocks Write some blocks of a stripe, keyed by block ID, to their active nodes concurrently
+func (r *RAID6) writeBlocks(meta *FileMeta, stripe int, blocks map[int][]byte) error {
+	nodes := r.stripeNodes(meta, stripe)
+	blockIDs := make([]int, 0, len(blocks))
+	for blockID := range blocks {
+		blockIDs = append(blockIDs, blockID)
+	}
+
+	err := workers.runErr(len(blockIDs), func(i int) error {
+		blockID := blockIDs[i]
+		node := r.node(nodes[blockPos(blockID, meta.DataDisks)])
+		if !node.writable() {
+			return nil // The block is rebuilt when the node is recovered
+		}
+
+		data := blocks[blockID]
+		return r.putBlock(node, newBlock(meta, stripe, blockID, &data))
+	})
+	if err != nil {
+		return err
+	}
+	return r.checkStripeNodes(meta, stripe)
+}
+
+// putBlock Write a block to its node. A node whose store is offline is taken out of service like
+// a failed node, the block is then rebuilt when the node is recovered.
+func (r *RAID6) putBlock(node *Node, block *Block) error {
+	err := node.WriteBlockToDisk(block)
+	if r.dropOffline(node, err) {
+		return nil
+	}
+	return err
+}
+
+// dropOffline Take a node out of service if err reports its store offline, and record it as failed.
+// A hot spare is promoted for it by promoteDropped once the worker pool of the write has returned.
+// in the superblocks of the other nodes. Reports whether the node was dropped.
+func (r *RAID6) dropOffline(node *Node, err error) bool {
+	if !errors.Is(err, ErrStoreOffline) {
+		return false
+	}
+
+	r.failMu.Lock()
+	defer r.failMu.Unlock()
+	if node.writable() {
+		node.status = false
+		node.rebuilding = false
+		r.updateMembership() // Best effort, the node is out of service either way
+		r.dropped = append(r.dropped, node)
+	}
+	return true
+}
+
+// checkStripeNodes Fail a write that left more blocks of a stripe on failed nodes than the code can rebuild
+func (r *RAID6) checkStripeNodes(meta *FileMeta, stripe int) error {
+	down := 0
+	for _, nodeID := range r.stripeNodes(meta, stripe) {
+		if !r.node(nodeID).writable() {
+			down++
+		}
+	}
+	if parityNum := len(meta.Nodes) - meta.DataDisks; down > parityNum {
+		return fmt.Errorf("stripe %d of file %s has %d blocks on failed nodes, more than its %d parities cover", stripe, meta.FileName, down, parityNum)
+	}
+	return nil
+}
+
+// readBlock Read one block of a stripe from its node, false if the node is down or the block is unreadable
+func (r *RAID6) readBlock(meta *FileMeta, stripe, blockID int) ([]byte, bool) {
+	node := r.node(r.stripeNodes(meta, stripe)[blockPos(blockID, meta.DataDisks)])
+	if !node.status {
+		return nil, false
+	}
+	data, err := node.ReadBlockFromDisk(meta.FileName, meta.Layout, stripe, blockID, meta.FileID)
+	if err != nil || len(data) != meta.BlockSize {
+		return nil, false
+	}
+	return data, true
+}
+
+// GetStripeBlocks Get data blocks and parities of a stripe from the active nodes, missing blocks are left empty
+func (r *RAID6) GetStripeBlocks(meta *FileMeta, stripe int) (dataBlocks [][]byte, parity [][]byte) {
+	dataBlocks = make([][]byte, meta.DataDisks)
+	parity = make([][]byte, len(meta.Nodes)-meta.DataDisks)
+
+	for i, nodeID := range r.stripeNodes(meta, stripe) {
+		node := r.node(nodeID)
+		if !node.status {
+			continue // Skip failed nodes, their blocks are treated as missing
+		}
+
+		blockID := blockIDAt(i, meta.DataDisks)
+		data, err := node.ReadBlockFromDisk(meta.FileName, meta.Layout, stripe, blockID, meta.FileID)
+		if err != nil || len(data) != meta.BlockSize {
+			continue
+		}
+
+		if blockID < 0 {
+			parity[-blockID-1] = data
+		} else {
+			dataBlocks[blockID] = data
+		}
+	}
+
+	return dataBlocks, parity
+}
+
+// readStripeData Read the data blocks of a stripe, reconstructing missing ones in memory
+func (r *RAID6) readStripeData(meta *FileMeta, stripe int) ([][]byte, error) {
+	dataBlocks, parity := r.GetStripeBlocks(meta, stripe)
+
+	err := r.Code.ReconstructData(dataBlocks, parity)
+	if err != nil {
+		return nil, err
+	}
+	return dataBlocks, nil
+}
+
+// RecoverStripe Rebuild the blocks of one stripe that belong to the given nodes, nodes outside the
+// file's layout are skipped
+func (r *RAID6) RecoverStripe(meta *FileMeta, stripe int, nodeIDs ...int) error {
+	if !meta.onNodes(nodeIDs...) {
+		return nil
+	}
+
+	dataBlocks, parity := r.GetStripeBlocks(meta, stripe)
+	err := r.Code.Reconstruct(dataBlocks, parity)
+	if err != nil {
+		return err
+	}
+
+	for _, nodeID := range nodeIDs {
+		blockID, ok := r.blockIDOnNode(meta, stripe, nodeID)
+		if !ok {
+			continue
+		}
+		node := r.node(nodeID)
+		if !node.writable() {
+			continue
+		}
+		data := stripeBlock(dataBlocks, parity, blockID)
+		err = node.WriteBlockToDisk(newBlock(meta, stripe, blockID, &data))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripeBlock Pick the data block or parity with the given block ID
+func stripeBlock(dataBlocks, parity [][]byte, blockID int) []byte {
+	if blockID < 0 {
+		return parity[-blockID-1]
+	}
+	return dataBlocks[blockID]
+}
+
+// deleteStripe Remove all blocks of a stripe from the active nodes
+func (r *RAID6) deleteStripe(meta *FileMeta, stripe int) error {
+	for i, nodeID := range r.stripeNodes(meta, stripe) {
+		node := r.node(nodeID)
+		if !node.writable() {
+			continue
+		}
+		err := node.DeleteBlockFromDisk(meta.FileName, meta.Layout, stripe, blockIDAt(i, meta.DataDisks))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
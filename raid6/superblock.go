@@ -0,0 +1,410 @@
+package raid6
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	SuperblockMagic    = "RAID6-DS"
+	LayoutVersion      = 2
+	superblockFileName = "superblock.json"
+)
+
+// Superblock Cluster identity and membership written to every disk
+type Superblock struct {
+	Magic         string   `json:"magic"`
+	ClusterID     string   `json:"cluster_id"`
+	DiskID        string   `json:"disk_id"` // Unique ID of this disk
+	NodeID        int      `json:"node_id"` // ID of the node owning this disk, -1 for a hot spare
+	Epoch         int      `json:"epoch"`   // Incremented on every membership change, the highest copy wins
+	DiskNum       int      `json:"disk_num"`
+	Generator     int      `json:"generator"`
+	Code          string   `json:"code"`          // Erasure code of the stripes, see ErasureCode
+	ParityBlocks  int      `json:"parity_blocks"` // Parity blocks per stripe
+	LayoutVersion int      `json:"layout_version"`
+	NodeIDs       []int    `json:"node_ids"` // IDs of all nodes in the cluster, in layout order
+	DiskIDs       []string `json:"disk_ids"` // Disk holding each node of NodeIDs
+	Spares        []string `json:"spares"`   // Disks registered as hot spares
+	Failed        []string `json:"failed"`   // Disks of NodeIDs taken out of service, mounted as failed until rebuilt
+}
+
+// newRandomID Random 128-bit ID used for clusters and disks
+func newRandomID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// WriteSuperblock writes the superblock to the node's disk
+func (n *Node) WriteSuperblock(sb *Superblock) error {
+	data, err := json.Marshal(sb)
+	if err != nil {
+		return err
+	}
+
+	return n.Store.Put(superblockFileName, data)
+}
+
+// ReadSuperblock reads the superblock from the node's disk
+func (n *Node) ReadSuperblock() (*Superblock, error) {
+	return readSuperblock(n.Store, n.DiskPath)
+}
+
+// RemoveSuperblock removes the superblock so the disk is no longer mounted as part of the cluster
+func (n *Node) RemoveSuperblock() error {
+	return n.Store.Delete(superblockFileName)
+}
+
+func readSuperblock(store BlockStore, diskPath string) (*Superblock, error) {
+	data, err := store.Get(superblockFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := &Superblock{}
+	err = json.Unmarshal(data, sb)
+	if err != nil || sb.Magic != SuperblockMagic {
+		return nil, fmt.Errorf("disk %s does not hold a RAID6 superblock", diskPath)
+	}
+	return sb, nil
+}
+
+// superblock Build the superblock describing this cluster for the given node
+func (r *RAID6) superblock(node *Node) *Superblock {
+	sb := r.membership()
+	sb.DiskID = node.DiskID
+	sb.NodeID = node.NodeID
+	return sb
+}
+
+// membership Build the superblock of the cluster without the fields of a particular disk
+func (r *RAID6) membership() *Superblock {
+	nodeIDs := make([]int, len(r.Nodes))
+	diskIDs := make([]string, len(r.Nodes))
+	for i, n := range r.Nodes {
+		nodeIDs[i] = n.NodeID
+		diskIDs[i] = n.DiskID
+	}
+	spares := make([]string, len(r.Spares))
+	for i, n := range r.Spares {
+		spares[i] = n.DiskID
+	}
+	var failed []string
+	for _, n := range r.Nodes {
+		if !n.writable() {
+			failed = append(failed, n.DiskID)
+		}
+	}
+
+	return &Superblock{
+		Magic:         SuperblockMagic,
+		ClusterID:     r.ClusterID,
+		Epoch:         r.Epoch,
+		DiskNum:       r.DiskNum,
+		Generator:     r.Math.generator,
+		Code:          r.Code.Name(),
+		ParityBlocks:  r.Code.ParityBlocks(),
+		LayoutVersion: LayoutVersion,
+		NodeIDs:       nodeIDs,
+		DiskIDs:       diskIDs,
+		Spares:        spares,
+		Failed:        failed,
+	}
+}
+
+// formatNode Stamp the node's disk with the cluster superblock
+func (r *RAID6) formatNode(node *Node) error {
+	return node.WriteSuperblock(r.superblock(node))
+}
+
+// updateMembership Start a new epoch and write the current membership to every reachable disk
+func (r *RAID6) updateMembership() error {
+	r.Epoch++
+	return r.writeMembership()
+}
+
+// writeMembership Write the membership of the current epoch to every reachable disk
+func (r *RAID6) writeMembership() error {
+	for _, node := range append(append([]*Node(nil), r.Nodes...), r.Spares...) {
+		if !node.writable() {
+			continue
+		}
+		err := node.WriteSuperblock(r.superblock(node))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenRAID6 Mount an existing cluster from the disks under basePath
+func OpenRAID6(basePath string) (*RAID6, error) {
+	entries, err := os.ReadDir(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster directory: %w", err)
+	}
+
+	// Collect the superblocks of every disk found under basePath
+	var ref *Superblock
+	var renumbering *Renumbering
+	superblocks := make(map[string]*Superblock)
+	disks := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		diskPath := filepath.Join(basePath, entry.Name())
+		sb, err := readSuperblock(NewDirStore(diskPath), diskPath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // Wiped or unformatted disk, treated as failed below
+			}
+			return nil, err
+		}
+
+		if ref != nil && sb.ClusterID != ref.ClusterID {
+			return nil, fmt.Errorf("refusing to mount disk %s: disk belongs to foreign cluster %s", diskPath, sb.ClusterID)
+		}
+		if _, ok := disks[sb.DiskID]; ok {
+			return nil, fmt.Errorf("refusing to mount disk %s: disk %s is already mounted from %s", diskPath, sb.DiskID, disks[sb.DiskID])
+		}
+		if ref == nil || sb.Epoch > ref.Epoch {
+			ref = sb
+		}
+		superblocks[diskPath] = sb
+		disks[sb.DiskID] = diskPath
+
+		rn, err := readRenumbering(NewDirStore(diskPath))
+		if err == nil && (renumbering == nil || rn.Membership.Epoch > renumbering.Membership.Epoch) {
+			renumbering = rn
+		}
+
+		// Blocks are replaced through temp files, drop the ones a crash left behind
+		err = NewDirStore(diskPath).RemoveTempFiles()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ref == nil {
+		return nil, errors.New("no RAID6 disks found")
+	}
+	if renumbering != nil && renumbering.Membership.ClusterID == ref.ClusterID && renumbering.Membership.Epoch > ref.Epoch {
+		// A decommission journaled its renumbering but did not get to stamp the disks
+		ref = renumbering.Membership
+	}
+	if ref.LayoutVersion != LayoutVersion {
+		return nil, fmt.Errorf("unsupported layout version %d", ref.LayoutVersion)
+	}
+	for diskPath, sb := range superblocks {
+		err = ref.checkCompatible(sb)
+		if err != nil {
+			return nil, fmt.Errorf("refusing to mount disk %s: %w", diskPath, err)
+		}
+	}
+
+	raid := &RAID6{
+		ClusterID:  ref.ClusterID,
+		Epoch:      ref.Epoch,
+		DiskNum:    ref.DiskNum,
+		Nodes:      make([]*Node, ref.DiskNum),
+		Math:       NewRAIDMath(ref.Generator),
+		FileNames:  make([]string, 0),
+		ChunkSize:  DefaultChunkSize,
+		creating:   make(map[string]bool),
+		restriping: make(map[string]*restripeTarget),
+	}
+	raid.Code, err = newErasureCode(ref.Code, ref.ParityBlocks, raid.Math)
+	if err != nil {
+		return nil, err
+	}
+	err = checkStripeWidth(raid.Code, raid.DiskNum)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mount the disks of the latest membership, disks retired in an earlier epoch are left alone
+	failed := make(map[string]bool)
+	for _, diskID := range ref.Failed {
+		failed[diskID] = true
+	}
+	missing := 0
+	stale := false
+	for i, nodeID := range ref.NodeIDs {
+		diskID := ref.DiskIDs[i]
+		diskPath, ok := disks[diskID]
+		if !ok {
+			// The disk is gone, keep the slot as a failed node so it can be recovered
+			missing++
+			diskPath = fmt.Sprintf("%s/disk_%d", basePath, nodeID)
+			raid.Nodes[i] = &Node{
+				NodeID:   nodeID,
+				DiskID:   diskID,
+				status:   false,
+				DiskPath: diskPath,
+				Store:    NewDirStore(diskPath),
+			}
+			continue
+		}
+		if superblocks[diskPath].Epoch == ref.Epoch && superblocks[diskPath].NodeID != nodeID {
+			return nil, fmt.Errorf("refusing to mount disk %s: it claims node %d instead of %d", diskPath, superblocks[diskPath].NodeID, nodeID)
+		}
+		stale = stale || superblocks[diskPath].Epoch != ref.Epoch
+		if failed[diskID] {
+			missing++ // Blocks on the disk may be older than the rest of the cluster, never serve them
+		}
+		raid.Nodes[i] = &Node{
+			NodeID:   nodeID,
+			DiskID:   diskID,
+			status:   !failed[diskID],
+			DiskPath: diskPath,
+			Store:    NewDirStore(diskPath),
+		}
+	}
+	if missing > raid.Code.ParityBlocks() {
+		return nil, fmt.Errorf("%d disks are missing, the cluster tolerates at most %d", missing, raid.Code.ParityBlocks())
+	}
+	for _, diskID := range ref.Spares {
+		diskPath, ok := disks[diskID]
+		if !ok {
+			stale = true // Lost spare, drop it from the membership
+			continue
+		}
+		raid.Spares = append(raid.Spares, &Node{
+			NodeID:   -1,
+			DiskID:   diskID,
+			status:   true,
+			DiskPath: diskPath,
+			Store:    NewDirStore(diskPath),
+		})
+	}
+
+	// Finish renumbering the nodes before anything refers to them by ID
+	if renumbering != nil {
+		err = raid.replayRenumbering(renumbering)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Bring disks that missed a membership change up to date
+	if stale {
+		err = raid.updateMembership()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Drop files deleted while some of the disks were away
+	for _, node := range raid.Nodes {
+		if !node.status {
+			continue
+		}
+		err = raid.applyTombstones(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Finish an update that was interrupted, before any stripe of it is read
+	err = raid.replayIntent()
+	if err != nil {
+		return nil, err
+	}
+
+	err = raid.ScanFileNames()
+	if err != nil {
+		return nil, err
+	}
+
+	// Resume rebuilds that were interrupted, the nodes only serve reads once they are complete
+	var rebuilding []int
+	for _, node := range raid.Nodes {
+		if !node.status {
+			continue
+		}
+		if _, err = node.ReadRebuildCheckpoint(); err == nil {
+			node.status = false
+			rebuilding = append(rebuilding, node.NodeID)
+		}
+	}
+	if len(rebuilding) > 0 {
+		raid.startRebuild(rebuilding...)
+	}
+
+	// Finish moving files still striped across an earlier membership
+	for _, fileName := range raid.FileNames {
+		meta, err := raid.getFileMeta(fileName)
+		if err == nil && !sameNodeIDs(meta.Nodes, raid.layoutNodes()) {
+			raid.startRestripe(raid.layoutNodes())
+			break
+		}
+	}
+	return raid, nil
+}
+
+// checkCompatible Check that another disk's superblock belongs to the same cluster.
+// Disks of the same epoch must agree on the membership, older disks are brought up to date on mount.
+func (sb *Superblock) checkCompatible(other *Superblock) error {
+	if other.ClusterID != sb.ClusterID {
+		return fmt.Errorf("disk belongs to foreign cluster %s", other.ClusterID)
+	}
+	if other.Generator != sb.Generator || other.LayoutVersion != sb.LayoutVersion || other.Code != sb.Code || other.ParityBlocks != sb.ParityBlocks {
+		return errors.New("disk geometry does not match the cluster")
+	}
+	if other.Epoch != sb.Epoch {
+		return nil
+	}
+	if other.DiskNum != sb.DiskNum || len(other.NodeIDs) != len(sb.NodeIDs) || len(other.DiskIDs) != len(sb.DiskIDs) {
+		return errors.New("disk membership does not match the cluster")
+	}
+	for i := range sb.NodeIDs {
+		if other.NodeIDs[i] != sb.NodeIDs[i] || other.DiskIDs[i] != sb.DiskIDs[i] {
+			return errors.New("disk membership does not match the cluster")
+		}
+	}
+	return nil
+}
+
+// ScanFileNames Rebuild the file catalog from the blocks and metadata found on the active nodes
+func (r *RAID6) ScanFileNames() error {
+	// Count on how many nodes each file has blocks
+	holders := make(map[string]int)
+	for _, node := range r.Nodes {
+		if !node.status {
+			continue
+		}
+		fileNames, err := node.ScanFileNames()
+		if err != nil {
+			return err
+		}
+		for _, fileName := range fileNames {
+			holders[fileName]++
+		}
+	}
+
+	// Keep the files that enough nodes agree on to be readable
+	fileNames := make([]string, 0, len(holders))
+	for fileName, count := range holders {
+		meta, err := r.getFileMeta(fileName)
+		if err != nil || count < meta.DataDisks {
+			continue
+		}
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	r.FileNames = fileNames
+	r.FileNum = len(fileNames)
+	return nil
+}
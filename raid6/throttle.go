@@ -0,0 +1,30 @@
+package raid6
+
+import "time"
+
+// throttle Keeps background I/O under a number of bytes per second by sleeping between operations
+type throttle struct {
+	rate  int64 // Bytes per second, 0 for unlimited
+	start time.Time
+	bytes int64
+}
+
+func newThrottle(rate int64) *throttle {
+	return &throttle{
+		rate:  rate,
+		start: time.Now(),
+	}
+}
+
+// wait Account for n bytes of I/O and sleep until the average rate is back under the limit
+func (t *throttle) wait(n int) {
+	if t.rate <= 0 {
+		return
+	}
+
+	t.bytes += int64(n)
+	expected := time.Duration(float64(t.bytes) / float64(t.rate) * float64(time.Second))
+	if elapsed := time.Since(t.start); expected > elapsed {
+		time.Sleep(expected - elapsed)
+	}
+}
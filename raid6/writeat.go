@@ -0,0 +1,139 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WriteAt Overwrite len(p) bytes of a file starting at offset off and return the number of bytes written.
+// Only the data blocks covering the range and the parities of their stripes are read and written back,
+// the parities are patched with the change of each data block. Stripes with a block on a failed node
+// are rebuilt and written in full instead. The file size is fixed, writes past the end fail, and the
+// metadata is left as it is. Writes larger than one journal record are journaled in batches: every
+// batch is atomic, but a crash between batches leaves only the earlier ones written.
+func (r *RAID6) WriteAt(fileName string, p []byte, off int64) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+	defer r.promoteDropped()
+
+	if !r.fileExists(fileName) {
+		return 0, errors.New("file does not exist")
+	}
+	if r.creating[fileName] {
+		return 0, errors.New("file is being replaced")
+	}
+	err := r.finishIntent()
+	if err != nil {
+		return 0, err
+	}
+	meta, err := r.getFileMeta(fileName)
+	if err != nil {
+		return 0, err
+	}
+	if off < 0 || off+int64(len(p)) > int64(meta.Size) {
+		return 0, fmt.Errorf("write of %d bytes at offset %d is outside file %s of %d bytes", len(p), off, fileName, meta.Size)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Journal the new blocks first so an interrupted write cannot leave data and parity disagreeing.
+	// No batch is final: the metadata does not change, so replay only needs the version to still match.
+	intent := &Intent{Meta: meta}
+	journaled := 0
+
+	o := int(off)
+	stripeSize := meta.BlockSize * meta.DataDisks
+	for stripe := o / stripeSize; stripe <= (o+len(p)-1)/stripeSize; stripe++ {
+		// Part of p falling into this stripe, relative to the start of the stripe
+		start := max(o, stripe*stripeSize)
+		end := min(o+len(p), (stripe+1)*stripeSize)
+		chunk := p[start-o : end-o]
+		pos := start - stripe*stripeSize
+
+		s, err := r.patchStripe(meta, stripe, pos, chunk)
+		if err != nil {
+			return 0, err
+		}
+		intent.Stripes = append(intent.Stripes, s)
+		journaled += meta.BlockSize * (len(s.DataBlocks) + len(s.Blocks))
+		if journaled >= maxIntentSize {
+			err = r.journal(intent)
+			if err != nil {
+				return 0, err
+			}
+			intent = &Intent{Meta: meta}
+			journaled = 0
+		}
+	}
+
+	err = r.journal(intent)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// patchStripe Compute the new blocks of a stripe with chunk written at byte pos of its data
+func (r *RAID6) patchStripe(meta *FileMeta, stripe, pos int, chunk []byte) (IntentStripe, error) {
+	first := pos / meta.BlockSize
+	last := (pos + len(chunk) - 1) / meta.BlockSize
+
+	// write Copy the part of chunk covering data block j into block
+	write := func(block []byte, j int) {
+		blockStart := j * meta.BlockSize
+		lo := max(pos, blockStart)
+		hi := min(pos+len(chunk), blockStart+meta.BlockSize)
+		copy(block[lo-blockStart:hi-blockStart], chunk[lo-pos:hi-pos])
+	}
+
+	if blocks, ok := r.patchBlocks(meta, stripe, first, last, write); ok {
+		return IntentStripe{Stripe: stripe, Blocks: blocks}, nil
+	}
+
+	// Degraded stripe or a code without parity deltas: rebuild the stripe and write all of it
+	dataBlocks, parity := r.GetStripeBlocks(meta, stripe)
+	err := r.Code.Reconstruct(dataBlocks, parity)
+	if err != nil {
+		return IntentStripe{}, fmt.Errorf("stripe %d: %w", stripe, err)
+	}
+	for j := first; j <= last; j++ {
+		write(dataBlocks[j], j)
+	}
+	return IntentStripe{Stripe: stripe, DataBlocks: dataBlocks}, nil
+}
+
+// patchBlocks Read the data blocks first to last and the parities of a stripe, apply write to the
+// data blocks and patch the parities with their change. False if a needed block cannot be read.
+func (r *RAID6) patchBlocks(meta *FileMeta, stripe, first, last int, write func(block []byte, j int)) (map[int][]byte, bool) {
+	code, ok := r.Code.(DeltaEncoder)
+	if !ok {
+		return nil, false
+	}
+
+	parity := make([][]byte, r.Code.ParityBlocks())
+	for i := range parity {
+		parity[i], ok = r.readBlock(meta, stripe, -(i + 1))
+		if !ok {
+			return nil, false
+		}
+	}
+
+	blocks := make(map[int][]byte)
+	for j := first; j <= last; j++ {
+		oldData, ok := r.readBlock(meta, stripe, j)
+		if !ok {
+			return nil, false
+		}
+		newData := append([]byte(nil), oldData...)
+		write(newData, j)
+
+		code.UpdateParity(parity, meta.DataDisks, j, r.Math.DataDelta(oldData, newData))
+		blocks[j] = newData
+	}
+
+	for i := range parity {
+		blocks[-(i + 1)] = parity[i]
+	}
+	return blocks, true
+}